@@ -1,6 +1,10 @@
 package smutje
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/gfrey/smutje/parser"
 	"github.com/pkg/errors"
 )
@@ -54,3 +58,65 @@ func (a Attributes) MergeInplace(b Attributes) error {
 	}
 	return nil
 }
+
+const defaultEnvPrefix = "SMUTJE_"
+
+// Environment returns the attributes as shell quoted environment variable
+// assignments (sorted by name), if exporting was enabled using the `EnvExport`
+// attribute. The names are prefixed (see the `EnvPrefix` attribute), upper
+// cased, and all characters not allowed in names are replaced with an
+// underscore. Passwords and facts are never exported (facts would change the
+// hashes of all steps). It is an error if two attributes map to the same
+// name.
+func (a Attributes) Environment() ([]string, error) {
+	raw, ok := a["EnvExport"]
+	if !ok {
+		return nil, nil
+	}
+	switch enabled, err := strconv.ParseBool(raw); {
+	case err != nil:
+		return nil, errors.Errorf("invalid value for attribute EnvExport: %q", raw)
+	case !enabled:
+		return nil, nil
+	}
+
+	prefix, ok := a["EnvPrefix"]
+	if !ok {
+		prefix = defaultEnvPrefix
+	}
+
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		if strings.HasPrefix(k, "PASSWORD_") || strings.HasPrefix(k, factsPrefix) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	names := map[string]string{}
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		name := envName(prefix + k)
+		if other, found := names[name]; found {
+			return nil, errors.Errorf("attributes %q and %q both map to environment variable %s", other, k, name)
+		}
+		names[name] = k
+		env = append(env, name+"="+shellQuote(a[k]))
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, name)
+}
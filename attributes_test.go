@@ -0,0 +1,48 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttributesEnvironment(t *testing.T) {
+	tt := []struct {
+		attrs Attributes
+		exp   []string
+	}{
+		{Attributes{"Foo": "bar"}, nil},
+		{Attributes{"EnvExport": "false", "Foo": "bar"}, nil},
+		{
+			Attributes{"EnvExport": "true", "Foo": "it's"},
+			[]string{"SMUTJE_ENVEXPORT='true'", `SMUTJE_FOO='it'"'"'s'`},
+		},
+		{
+			Attributes{"EnvExport": "1", "EnvPrefix": "app-", "App.Name": "shop", "PASSWORD_db_RAW": "secret"},
+			[]string{"APP_APP_NAME='shop'", "APP_ENVEXPORT='1'", "APP_ENVPREFIX='app-'"},
+		},
+		{
+			Attributes{"EnvExport": "1", "Facts.Distro": "debian", "Facts.Interfaces.eth0": "10.0.0.1"},
+			[]string{"SMUTJE_ENVEXPORT='1'"},
+		},
+	}
+
+	for i, tti := range tt {
+		env, err := tti.attrs.Environment()
+		if err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		if strings.Join(env, " ") != strings.Join(tti.exp, " ") {
+			t.Errorf("%d: expected %q, got %q", i, tti.exp, env)
+		}
+	}
+
+	if _, err := (Attributes{"EnvExport": "maybe"}).Environment(); err == nil {
+		t.Errorf("expected an error for an invalid EnvExport value, got none")
+	}
+
+	if _, err := (Attributes{"EnvExport": "1", "a-b": "x", "a_b": "y"}).Environment(); err == nil {
+		t.Errorf("expected an error for colliding names, got none")
+	}
+}
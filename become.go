@@ -0,0 +1,84 @@
+package smutje
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// becomeConfig describes the user remote commands are run as and how to
+// switch to it.
+type becomeConfig struct {
+	User   string
+	Method string
+}
+
+// parseBecome reads the `Become` and `BecomeMethod` attributes. If `Become` is
+// not set or set to false nil is returned, i.e. commands are run as the
+// connection's user.
+func parseBecome(attrs Attributes) (*becomeConfig, error) {
+	user, ok := attrs["Become"]
+	if !ok || user == "" {
+		return nil, nil
+	}
+
+	if b, err := strconv.ParseBool(user); err == nil {
+		if !b {
+			return nil, nil
+		}
+		user = "root"
+	}
+
+	cfg := &becomeConfig{User: user, Method: "sudo"}
+	if m, ok := attrs["BecomeMethod"]; ok {
+		cfg.Method = strings.ToLower(m)
+	}
+
+	switch cfg.Method {
+	case "sudo", "su", "doas":
+		return cfg, nil
+	default:
+		return nil, errors.Errorf("invalid value for attribute BecomeMethod: %q (expected sudo, su, or doas)", cfg.Method)
+	}
+}
+
+func (cfg *becomeConfig) equal(o *becomeConfig) bool {
+	if cfg == nil || o == nil {
+		return cfg == o
+	}
+	return *cfg == *o
+}
+
+// becomeClient runs all sessions as the configured user.
+type becomeClient struct {
+	gconn.Client
+
+	cfg *becomeConfig
+}
+
+func newBecomeClient(client gconn.Client, cfg *becomeConfig) gconn.Client {
+	if client == nil || cfg == nil {
+		return client
+	}
+	return &becomeClient{Client: client, cfg: cfg}
+}
+
+func (c *becomeClient) Unwrap() gconn.Client {
+	return c.Client
+}
+
+func (c *becomeClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	cmdArgs := append([]string{cmd}, args...)
+	switch c.cfg.Method {
+	case "sudo":
+		return c.Client.NewSession("sudo", append([]string{"-n", "-H", "-u", c.cfg.User, "--"}, cmdArgs...)...)
+	case "doas":
+		return c.Client.NewSession("doas", append([]string{"-n", "-u", c.cfg.User}, cmdArgs...)...)
+	case "su":
+		return c.Client.NewSession("su", c.cfg.User, "-c", shellQuote(strings.Join(cmdArgs, " ")))
+	default:
+		return nil, errors.Errorf("become method %s not supported", c.cfg.Method)
+	}
+}
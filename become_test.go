@@ -0,0 +1,193 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gfrey/gconn"
+)
+
+type recordingClient struct {
+	testClient
+
+	cmds []string
+}
+
+func (rc *recordingClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	rc.cmds = append(rc.cmds, strings.Join(append([]string{cmd}, args...), " "))
+	return rc.testClient.NewSession(cmd, args...)
+}
+
+func TestBecome(t *testing.T) {
+	tt := []struct {
+		attrs Attributes
+		exp   string
+	}{
+		{Attributes{}, "/usr/bin/env bash -c true"},
+		{Attributes{"Become": "true"}, "sudo -n -H -u root -- /usr/bin/env bash -c true"},
+		{Attributes{"Become": "false"}, "/usr/bin/env bash -c true"},
+		{Attributes{"Become": "false", "BecomeMethod": "doas"}, "/usr/bin/env bash -c true"},
+		{Attributes{"Become": "www", "BecomeMethod": "doas"}, "doas -n -u www /usr/bin/env bash -c true"},
+		{Attributes{"Become": "root", "BecomeMethod": "su"}, "su root -c '/usr/bin/env bash -c true'"},
+	}
+
+	for i, tti := range tt {
+		cfg, err := parseBecome(tti.attrs)
+		if err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		rc := &recordingClient{testClient: testClient{failIdx: -1}}
+		if _, err := newBecomeClient(rc, cfg).NewSession("/usr/bin/env", "bash", "-c", "true"); err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		if len(rc.cmds) != 1 || rc.cmds[0] != tti.exp {
+			t.Errorf("%d: expected command %q, got %q", i, tti.exp, rc.cmds)
+		}
+	}
+
+	if _, err := parseBecome(Attributes{"Become": "root", "BecomeMethod": "pfexec"}); err == nil {
+		t.Errorf("expected an error for an unsupported become method, got none")
+	}
+}
+
+func TestPackageBecome(t *testing.T) {
+	root := &becomeConfig{User: "root", Method: "sudo"}
+
+	tt := []struct {
+		resBecome *becomeConfig
+		attrs     Attributes
+		expPrefix string
+	}{
+		{nil, Attributes{}, "/usr/bin/env bash"},
+		{nil, Attributes{"Become": "false"}, "/usr/bin/env bash"},
+		{nil, Attributes{"Become": "www"}, "sudo -n -H -u www -- /usr/bin/env bash"},
+		{root, Attributes{}, "/usr/bin/env bash"},
+		{root, Attributes{"Become": "root"}, "/usr/bin/env bash"},
+		{root, Attributes{"Become": "false"}, "sudo -n -H -u pi -- /usr/bin/env bash"},
+	}
+
+	for i, tti := range tt {
+		pkg := &smPackage{ID: "foobar", Attributes: tti.attrs, loginUser: "pi", resBecome: tti.resBecome}
+		pkg.Scripts = []smScript{&bashScript{Script: "true"}}
+		if err := pkg.Prepare(&testClient{failIdx: -1}, Attributes{}); err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		rc := &recordingClient{testClient: testClient{failIdx: -1}}
+		if err := pkg.execStep(log.New(ioutil.Discard, "", 0), rc, 0, time.Now()); err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		if len(rc.cmds) != 1 || !strings.HasPrefix(rc.cmds[0], tti.expPrefix) {
+			t.Errorf("%d: expected command with prefix %q, got %q", i, tti.expPrefix, rc.cmds)
+		}
+	}
+}
+
+func TestPackageBecomeState(t *testing.T) {
+	root := &becomeConfig{User: "root", Method: "sudo"}
+
+	tt := []struct {
+		resBecome *becomeConfig
+		attrs     Attributes
+		expPrefix string
+	}{
+		{nil, Attributes{}, "/usr/bin/env bash"},
+		{nil, Attributes{"Become": "root"}, "sudo -n -H -u root -- /usr/bin/env bash"},
+		{nil, Attributes{"Become": "www"}, "/usr/bin/env bash"},
+		{root, Attributes{"Become": "false"}, "/usr/bin/env bash"},
+	}
+
+	for i, tti := range tt {
+		pkg := &smPackage{ID: "foobar", Attributes: tti.attrs, loginUser: "pi", resBecome: tti.resBecome}
+		pkg.Scripts = []smScript{&bashScript{Script: "true"}}
+
+		rc := &recordingClient{testClient: testClient{failIdx: -1}}
+		if err := pkg.Prepare(rc, Attributes{}); err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+		if err := pkg.writeTargetState(rc, []string{"+" + pkg.Scripts[0].Hash()}); err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		if len(rc.cmds) != 2 {
+			t.Errorf("%d: expected two state commands, got %q", i, rc.cmds)
+			continue
+		}
+		for _, cmd := range rc.cmds {
+			if !strings.HasPrefix(cmd, tti.expPrefix) {
+				t.Errorf("%d: expected command with prefix %q, got %q", i, tti.expPrefix, cmd)
+			}
+		}
+	}
+}
+
+func TestPackageBecomeRunDir(t *testing.T) {
+	client := &testClient{failIdx: -1}
+
+	pkg := &smPackage{ID: "foobar", Attributes: Attributes{"Become": "www"}, loginUser: "pi"}
+	pkg.Scripts = []smScript{&bashScript{Script: "true"}}
+	if err := pkg.Prepare(client, Attributes{}); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	res := &Resource{ID: "res", Attributes: Attributes{}, client: client}
+	res.Packages = []*smPackage{pkg}
+	if err := res.Provision(log.New(ioutil.Discard, "", 0)); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	// The run directory must be set inside the package's become, as the
+	// environment is reset when switching the user.
+	found := false
+	for _, s := range client.sessions {
+		if strings.Contains(s.command, "sudo -n -H -u www") {
+			found = true
+			if !strings.Contains(s.command, "sudo -n -H -u www -- env "+runDirEnv+"='/tmp/smutje/res_") {
+				t.Errorf("expected the run directory to be set for the package's user, got %q", s.command)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected a step run as the package's user, got none")
+	}
+}
+
+func TestPackageBecomeInjectPasswords(t *testing.T) {
+	tt := []struct {
+		attrs  Attributes
+		expErr bool
+	}{
+		{Attributes{}, false},
+		{Attributes{"Become": "false"}, false},
+		{Attributes{"Become": "www"}, true},
+	}
+
+	for i, tti := range tt {
+		attrs := Attributes{"Secrets": "command", "SecretsCommand": "printf 'become-secret-%s'"}
+		for k, v := range tti.attrs {
+			attrs[k] = v
+		}
+		pkg := &smPackage{ID: "foobar", Attributes: attrs, loginUser: "pi"}
+		pkg.Scripts = []smScript{&smutjeScript{ID: "s", rawCommand: ":inject_passwords become_test"}}
+
+		err := pkg.Prepare(nil, Attributes{})
+		switch {
+		case tti.expErr && (err == nil || !strings.Contains(err.Error(), "inject_passwords")):
+			t.Errorf("%d: expected an inject_passwords error, got: %v", i, err)
+		case !tti.expErr && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		}
+	}
+}
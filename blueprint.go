@@ -22,6 +22,9 @@ func newBlueprint(n *parser.AstNode) (string, error) {
 			if !ok {
 				return "", errors.Errorf("expected a string value, got %T", child.Value)
 			}
+			if bscript.Lang != "" && bscript.Lang != parser.LangJSON {
+				return "", errors.Errorf("%s code blocks are not supported in blueprints", bscript.Lang)
+			}
 			bprint = bscript.Script
 		case parser.AstText:
 			// ignore
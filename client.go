@@ -0,0 +1,217 @@
+package smutje
+
+import (
+	"log"
+	"time"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	reconnectDelay    = 10 * time.Second
+	reconnectInterval = 5 * time.Second
+
+	sessionCloseGrace = 5 * time.Second
+)
+
+// A wrappingClient is a client that hands sessions to an underlying client.
+// Use unwrapClient to find specific implementations in a chain of clients.
+type wrappingClient interface {
+	Unwrap() gconn.Client
+}
+
+// unwrapClient walks the chain of wrapped clients and returns the first one
+// the given function matches for.
+func unwrapClient(client gconn.Client, f func(gconn.Client) bool) gconn.Client {
+	for client != nil {
+		if f(client) {
+			return client
+		}
+		w, ok := client.(wrappingClient)
+		if !ok {
+			return nil
+		}
+		client = w.Unwrap()
+	}
+	return nil
+}
+
+// reconnectingClient is the client handed to the packages during provisioning.
+// It allows to replace the connection to the target, e.g. after a reboot.
+type reconnectingClient struct {
+	gconn.Client
+
+	connect func() (gconn.Client, error)
+}
+
+func newReconnectingClient(client gconn.Client, connect func() (gconn.Client, error)) *reconnectingClient {
+	return &reconnectingClient{Client: client, connect: connect}
+}
+
+func (c *reconnectingClient) Unwrap() gconn.Client {
+	return c.Client
+}
+
+// NewSession fails if Reconnect dropped the connection and couldn't establish a
+// new one (e.g. the target didn't come back after a reboot), so that the state
+// handling fails cleanly.
+func (c *reconnectingClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	if c.Client == nil {
+		return nil, errors.Errorf("not connected to the target")
+	}
+	return c.Client.NewSession(cmd, args...)
+}
+
+// Reconnect will drop the current connection and try to establish a new one
+// until the timeout is exceeded.
+func (c *reconnectingClient) Reconnect(l *log.Logger, timeout time.Duration) error {
+	if c.Client != nil {
+		_ = c.Client.Close()
+		c.Client = nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	time.Sleep(reconnectDelay)
+
+	l.Printf("waiting for the target to be reachable again")
+	for {
+		client, err := c.connect()
+		if err == nil {
+			c.Client = client
+			return nil
+		}
+
+		if time.Now().Add(reconnectInterval).After(deadline) {
+			return errors.Wrapf(err, "target not reachable after %s", timeout)
+		}
+		time.Sleep(reconnectInterval)
+	}
+}
+
+// findReconnectingClient returns the reconnecting client of the given chain of
+// clients, if there is one.
+func findReconnectingClient(client gconn.Client) (*reconnectingClient, bool) {
+	c := unwrapClient(client, func(c gconn.Client) bool {
+		_, ok := c.(*reconnectingClient)
+		return ok
+	})
+	if c == nil {
+		return nil, false
+	}
+	return c.(*reconnectingClient), true
+}
+
+// deadlineClient creates sessions that are closed if they are still running
+// when the deadline is reached.
+type deadlineClient struct {
+	gconn.Client
+
+	deadline time.Time
+}
+
+func newDeadlineClient(client gconn.Client, deadline time.Time) gconn.Client {
+	if deadline.IsZero() {
+		return client
+	}
+	return &deadlineClient{Client: client, deadline: deadline}
+}
+
+func (c *deadlineClient) Unwrap() gconn.Client {
+	return c.Client
+}
+
+func (c *deadlineClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	if !time.Now().Before(c.deadline) {
+		return nil, errTimeout
+	}
+
+	sess, err := c.Client.NewSession(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &deadlineSession{Session: sess, deadline: c.deadline}, nil
+}
+
+// clientDeadline returns the deadline of the given chain of clients, if there
+// is one. It is used for commands not run on the target.
+func clientDeadline(client gconn.Client) (time.Time, bool) {
+	c := unwrapClient(client, func(c gconn.Client) bool {
+		_, ok := c.(*deadlineClient)
+		return ok
+	})
+	if c == nil {
+		return time.Time{}, false
+	}
+	return c.(*deadlineClient).deadline, true
+}
+
+var errTimeout = errors.New("timeout exceeded")
+
+type deadlineSession struct {
+	gconn.Session
+
+	deadline time.Time
+}
+
+func (s *deadlineSession) Run() error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Wait for the session to finish. If the deadline is reached first, the session
+// is closed, so that the remote command is terminated.
+func (s *deadlineSession) Wait() error {
+	errC := make(chan error, 1)
+	go func() { errC <- s.Session.Wait() }()
+
+	timer := time.NewTimer(time.Until(s.deadline))
+	defer timer.Stop()
+
+	select {
+	case err := <-errC:
+		return err
+	case <-timer.C:
+		// SSH sessions support sending signals, which is more reliable than
+		// just closing the session.
+		if sig, ok := s.Session.(interface{ Signal(ssh.Signal) error }); ok {
+			_ = sig.Signal(ssh.SIGKILL)
+		}
+		_ = s.Session.Close()
+
+		// Closing the session makes the underlying Wait return, so that the
+		// goroutine terminates. Don't block forever if it doesn't, though.
+		select {
+		case <-errC:
+		case <-time.After(sessionCloseGrace):
+		}
+		return errTimeout
+	}
+}
+
+// envClient sets the given environment variables for all sessions. The
+// assignments must be quoted for the shell already.
+type envClient struct {
+	gconn.Client
+
+	env []string
+}
+
+func newEnvClient(client gconn.Client, env []string) gconn.Client {
+	if len(env) == 0 {
+		return client
+	}
+	return &envClient{Client: client, env: env}
+}
+
+func (c *envClient) Unwrap() gconn.Client {
+	return c.Client
+}
+
+func (c *envClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	envArgs := append(append(append([]string{}, c.env...), cmd), args...)
+	return c.Client.NewSession("env", envArgs...)
+}
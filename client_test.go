@@ -0,0 +1,111 @@
+package smutje
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// blockingSession is a session, whose Wait only returns after it was closed.
+type blockingSession struct {
+	testSession
+
+	closed  chan struct{}
+	waiting chan struct{}
+}
+
+func (s *blockingSession) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func (s *blockingSession) Wait() error {
+	<-s.closed
+	close(s.waiting)
+	return nil
+}
+
+func TestDeadlineSession(t *testing.T) {
+	sess := &blockingSession{closed: make(chan struct{}), waiting: make(chan struct{})}
+	ds := &deadlineSession{Session: sess, deadline: time.Now().Add(10 * time.Millisecond)}
+
+	if err := ds.Wait(); err != errTimeout {
+		t.Errorf("expected a timeout error, got: %v", err)
+	}
+
+	select {
+	case <-sess.waiting:
+	default:
+		t.Errorf("expected the underlying Wait to have returned")
+	}
+}
+
+// brokenPipeSession fails to provide a stderr pipe.
+type brokenPipeSession struct {
+	testSession
+
+	closed bool
+}
+
+func (s *brokenPipeSession) StderrPipe() (io.Reader, error) {
+	return nil, errors.Errorf("no pipe")
+}
+
+func (s *brokenPipeSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+type sessionClient struct {
+	testClient
+
+	sess gconn.Session
+}
+
+func (c *sessionClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	return c.sess, nil
+}
+
+func TestLoggedClientPipeFailure(t *testing.T) {
+	sess := new(brokenPipeSession)
+	client := newLoggedClient(log.New(ioutil.Discard, "", 0), &sessionClient{sess: sess})
+
+	if _, err := client.NewSession("true"); err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+	if !sess.closed {
+		t.Errorf("expected the session to be closed")
+	}
+}
+
+func TestPackageDeadline(t *testing.T) {
+	now := time.Now()
+	start := now.Add(-time.Minute)
+
+	tt := []struct {
+		resDeadline time.Time
+		timeout     time.Duration
+		stepTimeout time.Duration
+		exp         time.Time
+	}{
+		{time.Time{}, 0, 0, time.Time{}},
+		{now.Add(time.Hour), 0, 0, now.Add(time.Hour)},
+		{now.Add(time.Hour), 2 * time.Minute, 0, start.Add(2 * time.Minute)},
+		{now.Add(time.Minute), 10 * time.Minute, 0, now.Add(time.Minute)},
+		{time.Time{}, 10 * time.Minute, time.Minute, now.Add(time.Minute)},
+		{now.Add(time.Second), 10 * time.Minute, time.Minute, now.Add(time.Second)},
+	}
+
+	for i, tti := range tt {
+		pkg := &smPackage{resDeadline: tti.resDeadline, timeout: tti.timeout, stepTimeout: tti.stepTimeout}
+		got := pkg.deadline(start)
+		if got.IsZero() != tti.exp.IsZero() || got.Sub(tti.exp) > time.Second || tti.exp.Sub(got) > time.Second {
+			t.Errorf("%d: expected deadline %s, got %s", i, tti.exp, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -15,14 +16,24 @@ func main() {
 }
 
 func run() error {
-	if len(os.Args) != 2 {
-		return errors.Errorf("usage: %s <smt-file>", os.Args[0])
+	if len(os.Args) > 1 && os.Args[1] == "secrets" {
+		return runSecrets(os.Args[2:])
 	}
 
-	tgt, err := smutje.ReadFile(os.Args[1])
+	stepTimeout := flag.Duration("step-timeout", 0, "maximum duration of a single step (0 for no limit)")
+	logDir := flag.String("log-dir", "", "directory to log the output of each step to")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		return errors.Errorf("usage: %[1]s [flags] <smt-file>\n       %[1]s secrets [flags] <command>", os.Args[0])
+	}
+
+	tgt, err := smutje.ReadFile(flag.Arg(0))
 	if err != nil {
 		return err
 	}
+	tgt.StepTimeout = *stepTimeout
+	tgt.LogDir = *logDir
 
 	return smutje.Provision(tgt)
 }
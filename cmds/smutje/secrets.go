@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gfrey/smutje"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const secretsUsage = `usage: %s secrets [flags] <command>
+
+commands:
+  edit                 edit the secrets using $EDITOR
+  get <name>           print the secret with the given name
+  set <name>           set the secret (read from the terminal or stdin)
+  list                 list the names of all secrets
+  rekey                encrypt the secrets with a new passphrase
+
+flags:
+`
+
+func runSecrets(args []string) error {
+	fs := flag.NewFlagSet("secrets", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), secretsUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	filename := fs.String("file", smutje.DefaultSecretsFile, "encrypted secrets file")
+	keyFile := fs.String("key-file", "", "file containing the passphrase")
+	newKeyFile := fs.String("new-key-file", "", "file containing the new passphrase (rekey only)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.Errorf("no secrets command given")
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch {
+	case cmd == "get" && len(args) == 1:
+		secrets, _, err := openSecrets(*filename, *keyFile, false)
+		if err != nil {
+			return err
+		}
+		s, ok := secrets[args[0]]
+		if !ok {
+			return errors.Errorf("secret %q not found", args[0])
+		}
+		fmt.Println(s)
+		return nil
+	case cmd == "list" && len(args) == 0:
+		secrets, _, err := openSecrets(*filename, *keyFile, false)
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for name := range secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	case cmd == "set" && len(args) == 1:
+		// The value is never given as argument, as it would be visible in
+		// the process list and the shell's history.
+		secrets, pass, err := openSecrets(*filename, *keyFile, true)
+		if err != nil {
+			return err
+		}
+
+		value, err := readSecret(fmt.Sprintf("value of %s: ", args[0]))
+		if err != nil {
+			return err
+		}
+		secrets[args[0]] = value
+		return smutje.WriteSecretsFile(*filename, pass, secrets)
+	case cmd == "edit" && len(args) == 0:
+		secrets, pass, err := openSecrets(*filename, *keyFile, true)
+		if err != nil {
+			return err
+		}
+		if secrets, err = editSecrets(secrets); err != nil {
+			return err
+		}
+		return smutje.WriteSecretsFile(*filename, pass, secrets)
+	case cmd == "rekey" && len(args) == 0:
+		secrets, _, err := openSecrets(*filename, *keyFile, false)
+		if err != nil {
+			return err
+		}
+		pass, err := newPassphrase(*newKeyFile)
+		if err != nil {
+			return err
+		}
+		return smutje.WriteSecretsFile(*filename, pass, secrets)
+	default:
+		fs.Usage()
+		return errors.Errorf("invalid secrets command %q", strings.Join(fs.Args(), " "))
+	}
+}
+
+// openSecrets reads the secrets file. If it doesn't exist and create is set, a
+// new passphrase is asked for.
+func openSecrets(filename, keyFile string, create bool) (map[string]string, []byte, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) && create {
+		pass, err := newPassphrase(keyFile)
+		return map[string]string{}, pass, err
+	}
+
+	pass, err := smutje.SecretsPassphrase(keyFile)
+	if err != nil {
+		if keyFile != "" || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, nil, err
+		}
+		if pass, err = readPassword("passphrase: "); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	secrets, err := smutje.ReadSecretsFile(filename, pass)
+	return secrets, pass, err
+}
+
+// newPassphrase returns the passphrase given in the key file or asks for it
+// (twice) on the terminal.
+func newPassphrase(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		return smutje.SecretsPassphrase(keyFile)
+	}
+
+	pass, err := readPassword("new passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := readPassword("repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, confirm) {
+		return nil, errors.Errorf("passphrases don't match")
+	}
+	if len(pass) == 0 {
+		return nil, errors.Errorf("empty passphrase given")
+	}
+	return pass, nil
+}
+
+func readPassword(prompt string) ([]byte, error) {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.Errorf("can't ask for passphrase, stdin is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return pass, errors.Wrap(err, "failed to read passphrase")
+}
+
+// readSecret reads a secret from the terminal (without echoing it) or the
+// first line of stdin.
+func readSecret(prompt string) (string, error) {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		s, err := readPassword(prompt)
+		return string(s), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "failed to read secret from stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// editSecrets writes the secrets to a private temporary file and opens it in
+// the user's editor. If the edited secrets can't be parsed, the editor is
+// opened again (unless declined), so that changes aren't lost. The file is
+// removed afterwards.
+func editSecrets(secrets map[string]string) (map[string]string, error) {
+	dir, err := ioutil.TempDir("", "smutje-secrets")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "secrets")
+	if err := ioutil.WriteFile(filename, smutje.FormatSecrets(secrets), 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to write temporary file")
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", filename)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, errors.Wrap(err, "editor failed")
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read edited secrets")
+		}
+
+		secrets, err := smutje.ParseSecrets(bytes.NewReader(data))
+		if err == nil {
+			return secrets, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "invalid secrets: %s\nopen the editor again? [Y/n] ", err)
+		answer, _ := stdin.ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer == "n" || answer == "no" {
+			return nil, errors.Wrap(err, "secrets not changed")
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package smutje
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gfrey/gconn"
+)
+
+// Command is the interface implemented by the commands available in smutje
+// script code blocks (lines starting with a colon).
+//
+// Prepare is called with the attributes of the package and the hash of the
+// preceding step. It must return the hash of the command, that must cover the
+// previous hash and everything that determines the outcome of the command (Hash
+// returns the same value afterwards). Exec runs the command on the target. If
+// MustExecute returns true, the command is run even if the cached state says
+// otherwise.
+//
+// Commands can additionally implement the following methods:
+//
+//   - `SetClient(gconn.Client)` to get access to the target while being
+//     prepared (the client is nil if the target doesn't exist yet).
+//   - `Exports() Attributes` to provide attributes to the following packages.
+//   - `InterruptsConnection() bool` to have the package state persisted right
+//     after the command was executed and the connection was re-established
+//     (like for a reboot).
+//   - `HandlesEnvironment() bool` to apply the exported environment (see
+//     Attributes.Environment) itself, instead of having it applied to the
+//     client.
+type Command interface {
+	Prepare(attrs Attributes, prevHash string) (string, error)
+	Exec(l *log.Logger, client gconn.Client) error
+	Hash() string
+	MustExecute() bool
+}
+
+// CommandArgs contains the information available for creating a command.
+type CommandArgs struct {
+	// Path is the directory of the file the command was given in. Relative
+	// paths should be resolved using it.
+	Path string
+	// Args are the arguments of the command (without the command's name),
+	// split at whitespace.
+	Args []string
+	// Raw is the unsplit remainder of the command line.
+	Raw string
+}
+
+// Quoted returns the arguments split like the shell would do, i.e. arguments
+// containing whitespace can be given in single or double quotes.
+func (a CommandArgs) Quoted() ([]string, error) {
+	return splitArgs(a.Raw)
+}
+
+// A CommandFactory creates a command from the given arguments. The attributes
+// are already rendered into the arguments. It must return either a command
+// or an error.
+type CommandFactory func(args CommandArgs) (Command, error)
+
+var (
+	commandsMu sync.RWMutex
+	commands   = map[string]CommandFactory{}
+)
+
+// RegisterCommand makes a command available under the given name (the leading
+// colon is optional, names are case insensitive). This is meant to be called
+// from a package's init function. It panics if the factory is nil or a command
+// with the same name is registered already.
+func RegisterCommand(name string, factory CommandFactory) {
+	name = commandName(name)
+	if name == "" {
+		panic("smutje: RegisterCommand with empty name")
+	}
+	if factory == nil {
+		panic("smutje: RegisterCommand factory is nil for " + name)
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	if _, dup := commands[name]; dup {
+		panic("smutje: RegisterCommand called twice for " + name)
+	}
+	commands[name] = factory
+}
+
+// Commands returns the sorted names of all registered commands.
+func Commands() []string {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, ":"+name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupCommand(name string) (CommandFactory, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	f, ok := commands[commandName(name)]
+	return f, ok
+}
+
+func commandName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, ":"))
+}
+
+func init() {
+	RegisterCommand("write_file", func(a CommandArgs) (Command, error) { return newExecWriteFileCmd(a.Path, a.Args) })
+	RegisterCommand("write_template", func(a CommandArgs) (Command, error) { return newExecWriteTemplateCmd(a.Path, a.Args) })
+	RegisterCommand("mkdir", func(a CommandArgs) (Command, error) { return newMkdirCmd(a.Args) })
+	RegisterCommand("symlink", func(a CommandArgs) (Command, error) { return newSymlinkCmd(a.Args) })
+	RegisterCommand("line_in_file", func(a CommandArgs) (Command, error) {
+		args, err := a.Quoted()
+		if err != nil {
+			return nil, err
+		}
+		return newLineInFileCmd(args)
+	})
+	RegisterCommand("block_in_file", func(a CommandArgs) (Command, error) {
+		args, err := a.Quoted()
+		if err != nil {
+			return nil, err
+		}
+		return newBlockInFileCmd(a.Path, args)
+	})
+	RegisterCommand("packages", func(a CommandArgs) (Command, error) { return newPackagesCmd(a.Args) })
+	RegisterCommand("service", func(a CommandArgs) (Command, error) { return newServiceCmd(a.Args) })
+	RegisterCommand("user", func(a CommandArgs) (Command, error) { return newUserCmd(a.Path, a.Args) })
+	RegisterCommand("group", func(a CommandArgs) (Command, error) { return newGroupCmd(a.Args) })
+	RegisterCommand("script", func(a CommandArgs) (Command, error) {
+		args, err := a.Quoted()
+		if err != nil {
+			return nil, err
+		}
+		return newScriptCmd(a.Path, args)
+	})
+	RegisterCommand("reboot", func(a CommandArgs) (Command, error) { return newRebootCmd(a.Args) })
+	RegisterCommand("wait_for", func(a CommandArgs) (Command, error) {
+		args, err := a.Quoted()
+		if err != nil {
+			return nil, err
+		}
+		return newWaitForCmd(args)
+	})
+	RegisterCommand("local", func(a CommandArgs) (Command, error) { return newLocalCmd(a.Path, a.Raw) })
+	RegisterCommand("capture", func(a CommandArgs) (Command, error) { return newCaptureCmd(a.Raw) })
+	RegisterCommand("jenkins_artifact", func(a CommandArgs) (Command, error) { return newJenkinsArtifactCmd(a.Args) })
+	RegisterCommand("inject_passwords", func(a CommandArgs) (Command, error) { return newInjectPasswordsCmd(a.Args) })
+}
@@ -0,0 +1,138 @@
+package smutje
+
+import (
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+type testCmd struct {
+	args CommandArgs
+	hash string
+
+	execs int
+	fail  bool
+}
+
+func (c *testCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	c.hash = prevHash + "test"
+	return c.hash, nil
+}
+
+func (c *testCmd) Exec(l *log.Logger, client gconn.Client) error {
+	c.execs++
+	if c.fail {
+		return errors.Errorf("asked to fail")
+	}
+	return nil
+}
+
+func (c *testCmd) Hash() string      { return c.hash }
+func (c *testCmd) MustExecute() bool { return false }
+
+// registerTestCommand registers a command for the duration of the test.
+func registerTestCommand(t *testing.T, name string, factory CommandFactory) {
+	RegisterCommand(name, factory)
+	t.Cleanup(func() {
+		commandsMu.Lock()
+		defer commandsMu.Unlock()
+		delete(commands, commandName(name))
+	})
+}
+
+func TestRegisterCommand(t *testing.T) {
+	var created *testCmd
+	registerTestCommand(t, ":Test_Cmd", func(args CommandArgs) (Command, error) {
+		created = &testCmd{args: args}
+		return created, nil
+	})
+
+	s := &smutjeScript{ID: "s", Path: "/some/path", rawCommand: `:test_cmd {{ .Value }} "b c"`}
+	hash, err := s.Prepare(Attributes{"Value": "a"}, "prev")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	if created == nil {
+		t.Fatalf("expected the registered command to be created")
+	}
+
+	quoted, err := created.args.Quoted()
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	tt := []struct {
+		got interface{}
+		exp interface{}
+		msg string
+	}{
+		{created.args.Path, "/some/path", "path is handed to the factory"},
+		{len(created.args.Args), 3, "arguments are split at whitespace"},
+		{created.args.Args[0], "a", "attributes are rendered"},
+		{created.args.Args[1], `"b`, "quotes are kept in the split arguments"},
+		{len(quoted), 2, "quoted arguments are split like the shell does"},
+		{quoted[1], "b c", "quoted arguments are kept together"},
+		{created.args.Raw, ` a "b c"`, "raw arguments are available"},
+		{hash, "prevtest", "hash is determined by the command"},
+		{s.Hash(), "prevtest", "hash is returned by the script"},
+	}
+
+	for _, tc := range tt {
+		if tc.got != tc.exp {
+			t.Errorf("%s: expected %v, got %v", tc.msg, tc.exp, tc.got)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected registering a duplicate command to panic")
+			}
+		}()
+		RegisterCommand("test_cmd", func(CommandArgs) (Command, error) { return nil, nil })
+	}()
+}
+
+func TestUnknownCommand(t *testing.T) {
+	s := &smutjeScript{ID: "s", rawCommand: ":no_such_command"}
+	if _, err := s.Prepare(Attributes{}, ""); err == nil {
+		t.Errorf("expected an error for an unknown command")
+	}
+}
+
+func TestNilCommand(t *testing.T) {
+	registerTestCommand(t, "test_nil_cmd", func(CommandArgs) (Command, error) { return nil, nil })
+
+	s := &smutjeScript{ID: "s", rawCommand: ":test_nil_cmd"}
+	if _, err := s.Prepare(Attributes{}, ""); err == nil {
+		t.Errorf("expected an error for a factory returning no command")
+	}
+}
+
+func TestCommandArguments(t *testing.T) {
+	tt := []struct {
+		raw    string
+		expErr string
+	}{
+		{":mkdir /tmp/it's", ""},
+		{`:line_in_file /etc/motd "it's fine"`, ""},
+		{`:line_in_file /etc/motd it's`, "unterminated quote"},
+		{`:block_in_file /etc/x ""`, "empty file name"},
+		{`:script ""`, "empty file name"},
+	}
+
+	for i, tti := range tt {
+		s := &smutjeScript{ID: "s", rawCommand: tti.raw}
+		_, err := s.Prepare(Attributes{}, "")
+		switch {
+		case tti.expErr == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.expErr != "" && (err == nil || !strings.Contains(err.Error(), tti.expErr)):
+			t.Errorf("%d: expected error %q, got: %v", i, tti.expErr, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package smutje
+
+import (
+	"bufio"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+const factsPrefix = "Facts."
+
+// The script prints the facts as `key=value` lines. It must work on Linux
+// distributions as well as on SmartOS (global zone and zones).
+const factsScript = `
+os=$(uname -s | tr '[:upper:]' '[:lower:]')
+echo "OSFamily=$os"
+echo "Kernel=$(uname -r)"
+echo "Arch=$(uname -m)"
+echo "Hostname=$(hostname)"
+
+distro="$os"; version=""
+if [ -r /etc/os-release ]; then
+	distro=$(. /etc/os-release && echo "$ID")
+	version=$(. /etc/os-release && echo "$VERSION_ID")
+elif [ "$os" = sunos ]; then
+	distro=$(head -n 1 /etc/release 2>/dev/null | awk '{ print tolower($1) }')
+	version=$(uname -v)
+fi
+echo "Distro=$distro"
+echo "DistroVersion=$version"
+
+if [ "$os" = sunos ]; then
+	echo "CPUs=$(psrinfo 2>/dev/null | wc -l | tr -d ' ')"
+	echo "MemoryMB=$(prtconf -m 2>/dev/null)"
+	ifconfig -a 2>/dev/null | awk '/^[a-z]/ { i = $1; sub(":$", "", i) } / inet / { print "Interfaces." i "=" $2 }'
+else
+	echo "CPUs=$(nproc 2>/dev/null || getconf _NPROCESSORS_ONLN)"
+	echo "MemoryMB=$(awk '/^MemTotal:/ { print int($2 / 1024) }' /proc/meminfo)"
+	if command -v ip >/dev/null 2>&1; then
+		ip -o -4 addr show | awk '{ sub("/.*", "", $4); print "Interfaces." $2 "=" $4 }'
+	fi
+fi
+
+zone=false
+if command -v zonename >/dev/null 2>&1 && [ "$(zonename)" != global ]; then
+	zone=true
+fi
+echo "IsZone=$zone"
+
+kvm=false
+if grep -qiE 'kvm|qemu|hvm' /sys/class/dmi/id/product_name /sys/class/dmi/id/sys_vendor 2>/dev/null; then
+	kvm=true
+elif [ "$os" = sunos ] && [ "$zone" = false ] && smbios -t 1 2>/dev/null | grep -qiE 'kvm|qemu|hvm'; then
+	kvm=true
+fi
+echo "IsKVM=$kvm"
+`
+
+// gatherFacts collects information on the target. The facts are returned as
+// attributes prefixed with `Facts.`, so that they are available in templates
+// using `.Facts.<name>`.
+func gatherFacts(l *log.Logger, client gconn.Client) (Attributes, error) {
+	output, err := execRemoteScriptOutput(l, client, factsScript)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to gather facts")
+	}
+
+	facts := Attributes{}
+	sc := bufio.NewScanner(strings.NewReader(output))
+	for sc.Scan() {
+		parts := strings.SplitN(sc.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		facts[factsPrefix+parts[0]] = strings.TrimSpace(parts[1])
+	}
+	return facts, errors.Wrap(sc.Err(), "failed to parse facts")
+}
+
+// checkFactsAttributes returns an error, if the attributes contain keys that
+// are reserved for facts.
+func checkFactsAttributes(attrs Attributes) error {
+	for k := range attrs {
+		if strings.HasPrefix(k, factsPrefix) {
+			return errors.Errorf("attribute %q is reserved for facts", k)
+		}
+	}
+	return nil
+}
+
+// gatherFactsEnabled returns whether facts should be gathered, which can be
+// disabled using the `GatherFacts` attribute.
+func gatherFactsEnabled(attrs Attributes) (bool, error) {
+	raw, ok := attrs["GatherFacts"]
+	if !ok {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, errors.Errorf("invalid value for attribute GatherFacts: %q", raw)
+	}
+	return enabled, nil
+}
+
+// templateData returns the attributes as used in templates. Attributes with
+// dotted names (like facts) are turned into nested maps, i.e. the attribute
+// `Facts.Interfaces.eth0` is available as `.Facts.Interfaces.eth0`. Only the
+// first two dots are considered, as interface names may contain dots. It is an
+// error if an attribute is also the prefix of others (like `Facts` and
+// `Facts.Distro`).
+func templateData(attrs Attributes) (interface{}, error) {
+	nested := false
+	for k := range attrs {
+		nested = nested || strings.Contains(k, ".")
+	}
+	if !nested {
+		return attrs, nil
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range attrs {
+		parts := strings.SplitN(k, ".", 3)
+		m := data
+		for i, p := range parts[:len(parts)-1] {
+			if _, ok := m[p].(string); ok {
+				return nil, errors.Errorf("attribute %q conflicts with attribute %q", strings.Join(parts[:i+1], "."), k)
+			}
+			sub, ok := m[p].(map[string]interface{})
+			if !ok {
+				sub = map[string]interface{}{}
+				m[p] = sub
+			}
+			m = sub
+		}
+
+		leaf := parts[len(parts)-1]
+		if _, ok := m[leaf].(map[string]interface{}); ok {
+			return nil, errors.Errorf("attribute %q conflicts with attributes starting with %q", k, k+".")
+		}
+		m[leaf] = v
+	}
+	return data, nil
+}
@@ -0,0 +1,77 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderFacts(t *testing.T) {
+	attrs := Attributes{
+		"Name":                      "host",
+		"Facts.Distro":              "debian",
+		"Facts.Interfaces.eth0":     "10.0.0.1",
+		"Facts.Interfaces.eth0.100": "10.0.1.1",
+		"Facts.Interfaces.lo":       "127.0.0.1",
+	}
+
+	tt := []struct {
+		tmpl string
+		exp  string
+	}{
+		{"{{ .Name }}", "host"},
+		{"{{ .Facts.Distro }}", "debian"},
+		{`{{ if eq .Facts.Distro "debian" }}apt{{ else }}other{{ end }}`, "apt"},
+		{"{{ .Facts.Interfaces.eth0 }}", "10.0.0.1"},
+		{`{{ index .Facts.Interfaces "eth0.100" }}`, "10.0.1.1"},
+		{"{{ range $k, $v := .Facts.Interfaces }}{{ $k }} {{ end }}", "eth0 eth0.100 lo "},
+	}
+
+	for _, tti := range tt {
+		got, err := renderString("test", tti.tmpl, attrs)
+		if err != nil {
+			t.Errorf("%s: didn't expect an error, got: %s", tti.tmpl, err)
+			continue
+		}
+		if got != tti.exp {
+			t.Errorf("%s: expected %q, got %q", tti.tmpl, tti.exp, got)
+		}
+	}
+
+	if _, err := renderString("test", "{{ .Facts.Unknown }}", attrs); err == nil {
+		t.Errorf("expected an error for an unknown fact, got none")
+	}
+}
+
+func TestTemplateDataConflicts(t *testing.T) {
+	tt := []struct {
+		attrs Attributes
+		err   string
+	}{
+		{Attributes{"Facts": "x", "Facts.Distro": "debian"}, `attribute "Facts" conflicts`},
+		{Attributes{"Facts.Interfaces": "x", "Facts.Interfaces.eth0": "10.0.0.1"}, `attribute "Facts.Interfaces" conflicts`},
+		{Attributes{"Facts.Interfaces.eth0": "10.0.0.1", "Facts.Interfaces.eth0.100": "10.0.1.1"}, ""},
+		{Attributes{"Fact": "x", "Facts.Distro": "debian"}, ""},
+	}
+
+	for i, tti := range tt {
+		// The conflict must be detected independent of the map order.
+		for j := 0; j < 10; j++ {
+			_, err := templateData(tti.attrs)
+			switch {
+			case tti.err == "" && err != nil:
+				t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			case tti.err != "" && (err == nil || !strings.Contains(err.Error(), tti.err)):
+				t.Errorf("%d: expected error %q, got: %v", i, tti.err, err)
+			}
+		}
+	}
+}
+
+func TestCheckFactsAttributes(t *testing.T) {
+	if err := checkFactsAttributes(Attributes{"Facts": "x", "Name": "host"}); err != nil {
+		t.Errorf("didn't expect an error, got: %s", err)
+	}
+	if err := checkFactsAttributes(Attributes{"Facts.Distro": "debian"}); err == nil {
+		t.Errorf("expected an error for a reserved attribute, got none")
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/gfrey/gconn v0.0.0-20180812173902-fae770fe674c
 	github.com/gfrey/gmd v0.0.0-20210124140030-d78c7cf3a581
 	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.0.0-20200429183012-4b2356b1ed79
 )
 
 require (
@@ -15,7 +16,6 @@ require (
 	github.com/jgroeneveld/schema v1.0.0 // indirect
 	github.com/jgroeneveld/trial v2.0.0+incompatible // indirect
 	github.com/prometheus/common v0.9.1 // indirect
-	golang.org/x/crypto v0.0.0-20200429183012-4b2356b1ed79 // indirect
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a // indirect
 	golang.org/x/sys v0.0.0-20190422165155-953cdadca894 // indirect
 )
@@ -0,0 +1,164 @@
+package smutje
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// A notification records that a handler was notified by the step with the
+// given index and whether the handler was run already.
+type notification struct {
+	handler *smPackage
+	step    int
+	ran     bool
+}
+
+// splitNames splits a list of names separated by commas or whitespace.
+func splitNames(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// resolveHandlers looks up the handlers notified by the packages' steps.
+// Handlers defined in a template take precedence over the ones defined in the
+// including templates or the resource.
+func resolveHandlers(pkgs, handlers []*smPackage) error {
+	byID := map[string]*smPackage{}
+	for _, h := range handlers {
+		if len(h.notifyNames) > 0 {
+			return h.pos.Wrap(errors.Errorf("handler %s must not notify other handlers", h.ID))
+		}
+		byID[h.ID] = h
+	}
+
+	for _, pkg := range pkgs {
+		pkg.notify = map[int][]*smPackage{}
+		for idx, names := range pkg.notifyNames {
+			for _, name := range names {
+				h := lookupHandler(byID, pkg.ID, name)
+				if h == nil {
+					return pkg.pos.Wrap(errors.Errorf("package %s notifies unknown handler %q", pkg.ID, name))
+				}
+				pkg.notify[idx] = append(pkg.notify[idx], h)
+			}
+		}
+	}
+	return nil
+}
+
+func lookupHandler(byID map[string]*smPackage, pkgID, name string) *smPackage {
+	scope := pkgID
+	for {
+		idx := strings.LastIndex(scope, ".")
+		if idx == -1 {
+			return byID[name]
+		}
+		scope = scope[:idx]
+		if h, ok := byID[scope+"."+name]; ok {
+			return h
+		}
+	}
+}
+
+// notifyHandlers records the handlers notified by the executed step with the
+// given index. Each handler is recorded once, for the first step notifying it.
+func (pkg *smPackage) notifyHandlers(idx int) {
+	for _, hs := range [][]*smPackage{pkg.notify[-1], pkg.notify[idx]} {
+		for _, h := range hs {
+			if !pkg.isNotified(h) {
+				pkg.notified = append(pkg.notified, notification{handler: h, step: idx})
+			}
+		}
+	}
+}
+
+func (pkg *smPackage) isNotified(h *smPackage) bool {
+	for _, n := range pkg.notified {
+		if n.handler == h {
+			return true
+		}
+	}
+	return false
+}
+
+// runHandlers runs the notified handlers, that run at the end of the package.
+// If a handler fails, the notifying step is marked as failed (see
+// invalidatePending), so that it (and with it the handler) is executed again on
+// the next run.
+func (pkg *smPackage) runHandlers(l *log.Logger, client gconn.Client) error {
+	for _, n := range pkg.notified {
+		if n.handler.runAtResource {
+			continue
+		}
+		if err := n.handler.runHandler(l, client); err != nil {
+			return err
+		}
+		pkg.handlerRan(n.handler)
+	}
+	return nil
+}
+
+// handlerRan records that the given handler was run.
+func (pkg *smPackage) handlerRan(h *smPackage) {
+	for i := range pkg.notified {
+		if pkg.notified[i].handler == h {
+			pkg.notified[i].ran = true
+		}
+	}
+}
+
+// invalidatePending marks the steps notifying handlers, that were not run
+// (e.g. because of a failure), as failed. Thereby they (and with them the
+// handlers) are executed again on the next run. It returns whether the state
+// changed.
+func (pkg *smPackage) invalidatePending() bool {
+	invalidated := false
+	for _, n := range pkg.notified {
+		if !n.ran && n.step < len(pkg.state) && pkg.state[n.step][0] != '-' {
+			pkg.invalidateFrom(n.step)
+			invalidated = true
+		}
+	}
+	return invalidated
+}
+
+// invalidateFrom marks the step with the given index as failed and drops the
+// state of the following steps.
+func (pkg *smPackage) invalidateFrom(idx int) {
+	if idx < len(pkg.state) {
+		pkg.state[idx] = "-" + pkg.state[idx][1:]
+		pkg.state = pkg.state[:idx+1]
+	}
+}
+
+// runHandler executes all steps of the handler. There is no caching for
+// handlers, i.e. all steps are run each time the handler is notified.
+func (pkg *smPackage) runHandler(l *log.Logger, client gconn.Client) error {
+	l = tagLogger(l, pkg.ID)
+	l.Printf("running handler")
+
+	start := time.Now()
+	for i := range pkg.Scripts {
+		logFile := pkg.stepLogFile(i)
+		sl, closeLog, err := stepLogger(l, fmt.Sprintf("step%d", i), logFile)
+		if err != nil {
+			return err
+		}
+
+		err = pkg.execStep(sl, client, i, start)
+		if e := closeLog(); err == nil {
+			err = errors.Wrap(e, "failed to close step log file")
+		}
+		if err != nil {
+			l.Printf("handler failed in step %d", i)
+			return err
+		}
+	}
+	return nil
+}
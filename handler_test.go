@@ -0,0 +1,180 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestProvisionHandlers(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+
+	tt := []struct {
+		curState    []string
+		notifyIdx   int
+		stepFail    int
+		handlerFail bool
+		expExecs    int
+		expErr      bool
+		expState    []string
+	}{
+		{nil, 1, -1, false, 1, false, []string{hAE, hBE, hCE}},
+		{[]string{hAE, hBE}, 1, -1, false, 0, false, []string{hAC, hBC, hCE}},
+		{[]string{hAE, hBE}, 2, -1, false, 1, false, []string{hAC, hBC, hCE}},
+		{[]string{hAE, hBE}, -1, -1, false, 1, false, []string{hAC, hBC, hCE}},
+		{[]string{hAE, hBE, hCE}, -1, -1, false, 0, false, []string{hAE, hBE, hCE}},
+		{nil, 1, -1, true, 1, true, []string{hAE, hBF}},
+		{nil, 0, 1, false, 0, true, []string{hAF}},
+	}
+
+	for i, tti := range tt {
+		client := new(testClient)
+		client.failIdx = -1
+		if tti.curState != nil {
+			client.expCommand = "cat /var/lib/smutje/foobar.log"
+			client.cmdOutput = strings.Join(tti.curState, "\n")
+		}
+
+		cmd := &testCmd{fail: tti.handlerFail}
+		handler := &smPackage{ID: "handler", isHandler: true, Scripts: []smScript{cmd}}
+
+		pkg := new(smPackage)
+		pkg.ID = "foobar"
+		pkg.Scripts = []smScript{
+			&bashScript{Script: "echo foo"},
+			&smutjeScript{rawCommand: ":write_file testdata/a b"},
+			&bashScript{Script: "echo bar"},
+		}
+		pkg.notifyNames = map[int][]string{tti.notifyIdx: {"handler"}}
+
+		if err := resolveHandlers([]*smPackage{pkg}, []*smPackage{handler}); err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+
+		for _, p := range []*smPackage{pkg, handler} {
+			if err := p.Prepare(client, Attributes{}); err != nil {
+				t.Fatalf("didn't expect an error, got: %s", err)
+			}
+		}
+
+		client.curIdx = 0
+		client.failIdx = tti.stepFail
+		client.expCommand = ""
+
+		err := pkg.Provision(l, client)
+		if tti.expErr && err == nil {
+			t.Errorf("%d: expected an error, got none", i)
+		} else if !tti.expErr && err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		}
+
+		if cmd.execs != tti.expExecs {
+			t.Errorf("%d: expected handler to be executed %d times, got %d", i, tti.expExecs, cmd.execs)
+		}
+
+		if strings.Join(pkg.state, " ") != strings.Join(tti.expState, " ") {
+			t.Errorf("%d: expected state %q, got %q", i, tti.expState, pkg.state)
+		}
+	}
+}
+
+func TestLookupHandler(t *testing.T) {
+	byID := map[string]*smPackage{
+		"reload":       {ID: "reload"},
+		"inc.reload":   {ID: "inc.reload"},
+		"inc.sub.only": {ID: "inc.sub.only"},
+	}
+
+	tt := []struct {
+		pkgID string
+		name  string
+		exp   string
+	}{
+		{"pkg", "reload", "reload"},
+		{"inc.pkg", "reload", "inc.reload"},
+		{"inc.sub.pkg", "reload", "inc.reload"},
+		{"inc.sub.pkg", "only", "inc.sub.only"},
+		{"inc.pkg", "only", ""},
+		{"pkg", "unknown", ""},
+	}
+
+	for _, tti := range tt {
+		got := ""
+		if h := lookupHandler(byID, tti.pkgID, tti.name); h != nil {
+			got = h.ID
+		}
+		if got != tti.exp {
+			t.Errorf("%s/%s: expected handler %q, got %q", tti.pkgID, tti.name, tti.exp, got)
+		}
+	}
+}
+
+func TestProvisionResourceHandlers(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+
+	tt := []struct {
+		failIdx  int
+		expExecs int
+		expErr   bool
+		expState []string
+	}{
+		{-1, 1, false, []string{hAE}},
+		{2, 0, true, []string{hAF}},
+	}
+
+	for i, tti := range tt {
+		client := new(testClient)
+		client.failIdx = -1
+
+		cmd := &testCmd{}
+		handler := &smPackage{ID: "handler", isHandler: true, Scripts: []smScript{cmd}}
+		handler.Attributes = Attributes{"RunAt": "resource"}
+
+		first := &smPackage{ID: "first", Scripts: []smScript{&bashScript{Script: "echo foo"}}}
+		first.notifyNames = map[int][]string{0: {"handler"}}
+		second := &smPackage{ID: "second", Scripts: []smScript{&bashScript{Script: "echo foo"}}}
+
+		res := &Resource{ID: "res", Attributes: Attributes{}, client: client}
+		res.Packages = []*smPackage{first, second}
+		res.Handlers = []*smPackage{handler}
+		if err := resolveHandlers(res.Packages, res.Handlers); err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+
+		for _, p := range []*smPackage{first, second, handler} {
+			if err := p.Prepare(client, Attributes{}); err != nil {
+				t.Fatalf("didn't expect an error, got: %s", err)
+			}
+		}
+
+		// sessions: step of first, state of first, step of second
+		client.curIdx = 0
+		client.failIdx = tti.failIdx
+
+		err := res.Provision(l)
+		if tti.expErr && err == nil {
+			t.Errorf("%d: expected an error, got none", i)
+		} else if !tti.expErr && err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		}
+
+		if cmd.execs != tti.expExecs {
+			t.Errorf("%d: expected handler to be executed %d times, got %d", i, tti.expExecs, cmd.execs)
+		}
+
+		if strings.Join(first.state, " ") != strings.Join(tti.expState, " ") {
+			t.Errorf("%d: expected state %q, got %q", i, tti.expState, first.state)
+		}
+
+		last := ""
+		for _, s := range client.sessions {
+			if s.Stdin != nil && strings.Contains(s.command, "first.log") {
+				last = strings.TrimSpace(s.Stdin.String())
+			}
+		}
+		if last != strings.Join(tti.expState, "\n") {
+			t.Errorf("%d: expected persisted state %q, got %q", i, tti.expState, last)
+		}
+	}
+}
@@ -17,9 +17,9 @@ func newInclude(parentID, path string, attrs Attributes, n *parser.AstNode) ([]*
 	filename := filepath.Join(path, n.Name)
 	switch _, err := os.Lstat(filename); {
 	case os.IsNotExist(err):
-		return nil, errors.Errorf("template %s does not exist!", n.Name)
+		return nil, n.Pos.Wrap(errors.Errorf("template %s does not exist!", n.Name))
 	case err != nil:
-		return nil, err
+		return nil, n.Pos.Wrap(err)
 	}
 
 	incAttrs := attrs.Copy()
@@ -28,15 +28,15 @@ func newInclude(parentID, path string, attrs Attributes, n *parser.AstNode) ([]*
 		case parser.AstAttributes:
 			a, err := newAttributes(child)
 			if err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
 			}
 			if err := incAttrs.MergeInplace(a); err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
 			}
 		case parser.AstText:
 			// ignore
 		default:
-			return nil, errors.Errorf("unexpected node seen: %s", child.Type)
+			return nil, child.Pos.Wrap(errors.Errorf("unexpected node seen: %s", child.Type))
 		}
 	}
 
@@ -45,10 +45,13 @@ func newInclude(parentID, path string, attrs Attributes, n *parser.AstNode) ([]*
 		nodeID = parentID + "." + n.ID
 	}
 
-	return parseTemplate(filename, nodeID, incAttrs)
+	pkgs, err := parseTemplate(filename, nodeID, incAttrs, n.Pos)
+	return pkgs, n.Pos.Wrap(err)
 }
 
-func parseTemplate(filename, parentID string, attrs Attributes) ([]*smPackage, error) {
+// parseTemplate parses the template with the given filename. The position of
+// the include is recorded on all nodes, so that errors show the include chain.
+func parseTemplate(filename, parentID string, attrs Attributes, inc parser.Pos) ([]*smPackage, error) {
 	n, err := parser.Parse(filename)
 	if err != nil {
 		return nil, err
@@ -57,6 +60,7 @@ func parseTemplate(filename, parentID string, attrs Attributes) ([]*smPackage, e
 	if n.Type != parser.AstTemplate {
 		return nil, errors.Errorf("expected template node, got %s", n.Type)
 	}
+	n.SetIncludedFrom(inc)
 
 	pkgs := []*smPackage{}
 	tmplAttrs := attrs.Copy()
@@ -65,9 +69,11 @@ func parseTemplate(filename, parentID string, attrs Attributes) ([]*smPackage, e
 		case parser.AstAttributes:
 			newAttrs, err := newAttributes(child)
 			if err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
+			}
+			if err := tmplAttrs.MergeInplace(newAttrs); err != nil {
+				return nil, child.Pos.Wrap(err)
 			}
-			tmplAttrs.MergeInplace(newAttrs)
 		default:
 			npkgs, err := handleChild(parentID, filepath.Dir(filename), tmplAttrs, child)
 			if err != nil {
@@ -1,13 +1,180 @@
 package smutje
 
 import (
+	"bufio"
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
 )
 
 var logOutput io.Writer = os.Stdout
 
+const (
+	stdoutTag = "out |"
+	stderrTag = "ERR |"
+)
+
 func tagLogger(old *log.Logger, tag string) *log.Logger {
-	return log.New(logOutput, old.Prefix()+tag+" ", old.Flags())
+	return log.New(newRedactingWriter(logOutput), old.Prefix()+tag+" ", old.Flags())
+}
+
+// stepLogger creates a tagged logger for a single step. If a log file is
+// given, all output is sent to it, too. The returned function must be called
+// to close the file.
+func stepLogger(old *log.Logger, tag, filename string) (*log.Logger, func() error, error) {
+	if filename == "" {
+		return tagLogger(old, tag), func() error { return nil }, nil
+	}
+
+	fh, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create step log file")
+	}
+
+	l := log.New(newRedactingWriter(io.MultiWriter(logOutput, fh)), old.Prefix()+tag+" ", old.Flags())
+	return l, fh.Close, nil
+}
+
+// logStream sends each line read from the given reader to the logger.
+func logStream(l *log.Logger, tag string, r io.Reader, errC chan<- error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		l.Printf("%s %s", tag, sc.Text())
+	}
+	errC <- errors.Wrapf(sc.Err(), "failed scanning %s", tag)
+}
+
+// tailWriter keeps the last lines of the output of a step (stdout and stderr),
+// so that they can be repeated, e.g. when a failed step is retried.
+type tailWriter struct {
+	max int
+
+	mu      sync.Mutex
+	lines   []string
+	partial string
+}
+
+func newTailWriter(max int) *tailWriter {
+	return &tailWriter{max: max}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	parts := strings.Split(w.partial+string(p), "\n")
+	w.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		for _, tag := range []string{stdoutTag, stderrTag} {
+			if idx := strings.Index(line, tag); idx != -1 {
+				w.lines = append(w.lines, line[idx:])
+				break
+			}
+		}
+	}
+	if len(w.lines) > w.max {
+		w.lines = w.lines[len(w.lines)-w.max:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines.
+func (w *tailWriter) Lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string{}, w.lines...)
+}
+
+// loggedClient sends the output of all sessions line by line to the logger,
+// while the commands are running.
+type loggedClient struct {
+	gconn.Client
+
+	l *log.Logger
+}
+
+func newLoggedClient(l *log.Logger, client gconn.Client) gconn.Client {
+	return &loggedClient{Client: client, l: l}
+}
+
+func (c *loggedClient) Unwrap() gconn.Client {
+	return c.Client
+}
+
+func (c *loggedClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
+	sess, err := c.Client.NewSession(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, errors.Wrap(err, "failed to build stdout pipe")
+	}
+
+	stderr, err := sess.StderrPipe()
+	if err != nil {
+		sess.Close()
+		return nil, errors.Wrap(err, "failed to build stderr pipe")
+	}
+
+	ls := &loggedSession{Session: sess, errC: make(chan error, 2)}
+	go logStream(c.l, stdoutTag, stdout, ls.errC)
+	go logStream(c.l, stderrTag, stderr, ls.errC)
+	return ls, nil
+}
+
+type loggedSession struct {
+	gconn.Session
+
+	errC      chan error
+	once      sync.Once
+	streamErr error
+}
+
+func (s *loggedSession) StdoutPipe() (io.Reader, error) {
+	return nil, errors.New("logged session has no access to stdout pipe")
+}
+
+func (s *loggedSession) StderrPipe() (io.Reader, error) {
+	return nil, errors.New("logged session has no access to stderr pipe")
+}
+
+func (s *loggedSession) Run() error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+func (s *loggedSession) Wait() error {
+	err := s.Session.Wait()
+	if e := s.waitStreams(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (s *loggedSession) Close() error {
+	err := s.Session.Close()
+	_ = s.waitStreams()
+	return err
+}
+
+// waitStreams waits for both output streams to be read completely.
+func (s *loggedSession) waitStreams() error {
+	s.once.Do(func() {
+		for i := 0; i < 2; i++ {
+			if err := <-s.errC; err != nil && s.streamErr == nil {
+				s.streamErr = err
+			}
+		}
+	})
+	return s.streamErr
 }
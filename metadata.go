@@ -0,0 +1,55 @@
+package smutje
+
+// fileMetadata contains the ownership and permission information that can be
+// given to the commands creating entities in the target's file system.
+type fileMetadata struct {
+	Owner string
+	Group string
+	Mode  string
+}
+
+// parseFileMetadata will extract the metadata options (given in the form
+// `key=value`) from the given arguments. All remaining arguments are returned
+// in the original order.
+func parseFileMetadata(args []string) (*fileMetadata, []string) {
+	opts, rest := parseOptions(args, "owner", "group", "mode")
+	return &fileMetadata{Owner: opts["owner"], Group: opts["group"], Mode: opts["mode"]}, rest
+}
+
+// Hash returns the string used to identify the metadata in the command hash.
+// The values are separated so that they can't be confused. Without metadata
+// the hash is empty, so that the hashes of commands not using it stay as they
+// were.
+func (m *fileMetadata) Hash() string {
+	if m.Owner == "" && m.Group == "" && m.Mode == "" {
+		return ""
+	}
+	return "\nowner=" + m.Owner + "\ngroup=" + m.Group + "\nmode=" + m.Mode
+}
+
+// Commands returns the shell commands required to apply the metadata to the
+// given target. Each command is prefixed with `&&` so that the result can be
+// appended to the command creating the target. All values are quoted. With
+// noDeref set the ownership of a symlink is changed instead of the one of its
+// target.
+func (m *fileMetadata) Commands(target string, noDeref bool) string {
+	chown := "chown "
+	if noDeref {
+		chown += "-h "
+	}
+
+	cmd := ""
+	switch {
+	case m.Owner != "" && m.Group != "":
+		cmd += " && " + chown + shellQuote(m.Owner+":"+m.Group) + " " + shellQuote(target)
+	case m.Owner != "":
+		cmd += " && " + chown + shellQuote(m.Owner) + " " + shellQuote(target)
+	case m.Group != "":
+		cmd += " && " + chown + shellQuote(":"+m.Group) + " " + shellQuote(target)
+	}
+
+	if m.Mode != "" {
+		cmd += " && chmod " + shellQuote(m.Mode) + " " + shellQuote(target)
+	}
+	return cmd
+}
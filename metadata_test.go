@@ -0,0 +1,44 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileMetadata(t *testing.T) {
+	tt := []struct {
+		args    []string
+		expRest []string
+		expCmds string
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, ""},
+		{[]string{"a", "owner=peter"}, []string{"a"}, " && chown 'peter' 'tgt'"},
+		{[]string{"group=www", "a"}, []string{"a"}, " && chown ':www' 'tgt'"},
+		{[]string{"mode=0600", "a"}, []string{"a"}, " && chmod '0600' 'tgt'"},
+		{[]string{"a", "owner=peter", "group=www", "mode=0600"}, []string{"a"}, " && chown 'peter:www' 'tgt' && chmod '0600' 'tgt'"},
+		{[]string{"a=b", "owner=peter"}, []string{"a=b"}, " && chown 'peter' 'tgt'"},
+	}
+
+	for i, tti := range tt {
+		meta, rest := parseFileMetadata(tti.args)
+		if strings.Join(rest, " ") != strings.Join(tti.expRest, " ") {
+			t.Errorf("%d: expected remaining args %q, got %q", i, tti.expRest, rest)
+		}
+
+		if cmds := meta.Commands("tgt", false); cmds != tti.expCmds {
+			t.Errorf("%d: expected commands %q, got %q", i, tti.expCmds, cmds)
+		}
+	}
+}
+
+func TestFileMetadataHash(t *testing.T) {
+	a, _ := parseFileMetadata([]string{"owner=a", "mode=0600"})
+	b, _ := parseFileMetadata([]string{"owner=a0", "mode=600"})
+	if a.Hash() == b.Hash() {
+		t.Errorf("expected hashes of %q and %q to differ", a, b)
+	}
+
+	if m, _ := parseFileMetadata(nil); m.Hash() != "" {
+		t.Errorf("expected empty hash without metadata, got %q", m.Hash())
+	}
+}
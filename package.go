@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +16,11 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	defaultRetryDelay = 5 * time.Second
+	retryOutputLines  = 10
+)
+
 type smPackage struct {
 	Name string
 	ID   string
@@ -22,17 +28,43 @@ type smPackage struct {
 	Attributes Attributes
 	Scripts    []smScript
 
+	pos      parser.Pos
+	stepsPos []parser.Pos
+
+	attrs   Attributes
 	state   []string
 	isDirty bool
+
+	timeout     time.Duration
+	stepTimeout time.Duration
+	resDeadline time.Time
+	runDir      string
+	retries     int
+	retryDelay  time.Duration
+
+	loginUser string
+	resBecome *becomeConfig
+	become    *becomeConfig
+
+	logDir string
+
+	isHandler     bool
+	runAtResource bool
+	notifyNames   map[int][]string
+	notify        map[int][]*smPackage
+	notified      []notification
 }
 
 func newPackage(parentID, path string, attrs Attributes, n *parser.AstNode) (*smPackage, error) {
-	if n.Type != parser.AstPackage {
+	if n.Type != parser.AstPackage && n.Type != parser.AstHandler {
 		return nil, fmt.Errorf("expected package node, got %s", n.Type)
 	}
 
 	pkg := new(smPackage)
 	pkg.Name = n.Name
+	pkg.pos = n.Pos
+	pkg.isHandler = n.Type == parser.AstHandler
+	pkg.notifyNames = map[int][]string{}
 
 	pkg.ID = n.ID
 	if parentID != "" {
@@ -45,23 +77,31 @@ func newPackage(parentID, path string, attrs Attributes, n *parser.AstNode) (*sm
 		case parser.AstAttributes:
 			attrs, err := newAttributes(child)
 			if err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
+			}
+			if notify, ok := attrs["Notify"]; ok {
+				// Notifications belong to the preceding step, or to all steps
+				// if given before the first one.
+				delete(attrs, "Notify")
+				idx := len(pkg.Scripts) - 1
+				pkg.notifyNames[idx] = append(pkg.notifyNames[idx], splitNames(notify)...)
 			}
 			pkg.Attributes, err = attrs.Merge(pkg.Attributes)
 			if err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
 			}
 		case parser.AstScript:
 			child.ID = pkg.ID + "_" + strconv.Itoa(len(pkg.Scripts))
 			script, err := newScript(path, child)
 			if err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
 			}
 			pkg.Scripts = append(pkg.Scripts, script)
+			pkg.stepsPos = append(pkg.stepsPos, child.Pos)
 		case parser.AstText:
 		// ignore
 		default:
-			return nil, errors.Errorf("unexpected node found: %s", n.Type)
+			return nil, child.Pos.Wrap(errors.Errorf("unexpected node found: %s", child.Type))
 		}
 	}
 
@@ -69,31 +109,149 @@ func newPackage(parentID, path string, attrs Attributes, n *parser.AstNode) (*sm
 }
 
 func (pkg *smPackage) Prepare(client gconn.Client, attrs Attributes) (err error) {
-	if client != nil { // If a virtual resource doesn't exist yet, the client is nil!
+	defer func() { err = pkg.pos.Wrap(err) }()
+
+	// The state is read using the package's user, so it must be known first.
+	if pkg.become, err = parseBecome(pkg.Attributes); err != nil {
+		return err
+	}
+
+	if client != nil && !pkg.isHandler { // If a virtual resource doesn't exist yet, the client is nil!
 		pkg.state, err = pkg.readPackageState(client)
 		if err != nil {
 			return err
 		}
 	}
 
-	hash := ""
-	sattrs, err := attrs.Merge(pkg.Attributes)
+	pkg.attrs, err = attrs.Merge(pkg.Attributes)
 	if err != nil {
 		return err
 	}
-	for i, s := range pkg.Scripts {
-		hash, err = s.Prepare(sattrs, hash)
+
+	if pkg.timeout, err = pkg.durationAttribute("Timeout", 0); err != nil {
+		return err
+	}
+	if pkg.stepTimeout, err = pkg.durationAttribute("StepTimeout", pkg.stepTimeout); err != nil {
+		return err
+	}
+	if pkg.retryDelay, err = pkg.durationAttribute("RetryDelay", defaultRetryDelay); err != nil {
+		return err
+	}
+	if pkg.isHandler {
+		switch runAt := pkg.Attributes["RunAt"]; runAt {
+		case "", "package":
+			pkg.runAtResource = false
+		case "resource":
+			pkg.runAtResource = true
+		default:
+			return errors.Errorf("invalid value for attribute RunAt: %q (expected package or resource)", runAt)
+		}
+	}
+	if raw, ok := pkg.Attributes["Retries"]; ok {
+		if pkg.retries, err = strconv.Atoi(raw); err != nil || pkg.retries < 0 {
+			return errors.Errorf("invalid value for attribute Retries: %q", raw)
+		}
+	}
+
+	for _, s := range pkg.Scripts {
+		if tp, ok := s.(targetPreparer); ok {
+			tp.SetClient(client)
+		}
+	}
+
+	if err := pkg.prepareScripts(0, ""); err != nil {
+		return err
+	}
+
+	// The run directory is private to the resource's user, so passwords
+	// can't be injected by a different one.
+	if pkg.stepBecome() != nil {
+		for i, s := range pkg.Scripts {
+			if ss, ok := s.(*smutjeScript); ok {
+				if _, ok := ss.Command.(*execInjectPasswordsCmd); ok {
+					return pkg.stepPos(i).Wrap(errors.Errorf("inject_passwords requires the package's steps to run as the resource's user"))
+				}
+			}
+		}
+	}
+
+	for k, v := range pkg.exports() {
+		attrs[k] = v
+	}
+	return nil
+}
+
+// stepPos returns the position of the step with the given index in the smd
+// files.
+func (pkg *smPackage) stepPos(idx int) parser.Pos {
+	if idx < len(pkg.stepsPos) {
+		return pkg.stepsPos[idx]
+	}
+	return parser.Pos{}
+}
+
+// durationAttribute parses the package's attribute with the given key as a
+// duration. The default is returned if the attribute isn't set.
+func (pkg *smPackage) durationAttribute(key string, def time.Duration) (time.Duration, error) {
+	raw, ok := pkg.Attributes[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	return d, errors.Wrapf(err, "invalid value for attribute %s", key)
+}
+
+// deadline returns the deadline for a step started now. It is the earliest of
+// the resource's deadline, the timeout of the package (relative to the given
+// package start) and the step timeout. A zero value means there is no
+// deadline.
+func (pkg *smPackage) deadline(start time.Time) time.Time {
+	deadline := pkg.resDeadline
+	if pkg.timeout > 0 {
+		deadline = earliest(deadline, start.Add(pkg.timeout))
+	}
+	if pkg.stepTimeout > 0 {
+		deadline = earliest(deadline, time.Now().Add(pkg.stepTimeout))
+	}
+	return deadline
+}
+
+// earliest returns the earlier of the given deadlines, ignoring zero values.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// prepareScripts prepares the scripts starting with the given index, using
+// the hash of the preceding script.
+func (pkg *smPackage) prepareScripts(start int, hash string) (err error) {
+	for i := start; i < len(pkg.Scripts); i++ {
+		hash, err = pkg.Scripts[i].Prepare(pkg.attrs, hash)
 		if err != nil {
-			return err
+			return pkg.stepPos(i).Wrap(err)
 		}
 		if i >= len(pkg.state) || hash != pkg.state[i] {
 			pkg.isDirty = true
 		}
 	}
-
 	return nil
 }
 
+// exports returns the attributes exported by the package's scripts.
+func (pkg *smPackage) exports() Attributes {
+	attrs := Attributes{}
+	for _, s := range pkg.Scripts {
+		if es, ok := s.(exportingScript); ok {
+			for k, v := range es.Exports() {
+				attrs[k] = v
+			}
+		}
+	}
+	return attrs
+}
+
 func (pkg *smPackage) firstToExec() int {
 	firstToExec := -1
 	for i, s := range pkg.Scripts {
@@ -115,6 +273,7 @@ func (pkg *smPackage) firstToExec() int {
 func (pkg *smPackage) Provision(l *log.Logger, client gconn.Client) (err error) {
 	l = tagLogger(l, pkg.ID)
 
+	pkg.notified = nil
 	firstToExec := pkg.firstToExec()
 	if firstToExec == -1 {
 		l.Printf("all steps cached")
@@ -122,12 +281,16 @@ func (pkg *smPackage) Provision(l *log.Logger, client gconn.Client) (err error)
 	}
 
 	defer func() {
-		e := pkg.writeTargetState(client)
+		if err != nil {
+			pkg.invalidatePending()
+		}
+		e := pkg.writeTargetState(client, pkg.state)
 		if err == nil {
 			err = e
 		}
 	}()
 
+	start := time.Now()
 	pkg.state = make([]string, len(pkg.Scripts))
 	for i, s := range pkg.Scripts {
 		hash := s.Hash()
@@ -137,19 +300,133 @@ func (pkg *smPackage) Provision(l *log.Logger, client gconn.Client) (err error)
 			continue
 		}
 
-		if err = s.Exec(l, client); err != nil {
-			l.Printf("failed in %s", hash)
+		logFile := pkg.stepLogFile(i)
+		sl, closeLog, err := stepLogger(l, fmt.Sprintf("step%d", i), logFile)
+		if err != nil {
+			return err
+		}
+
+		err = pkg.execStep(sl, client, i, start)
+		if e := closeLog(); err == nil {
+			err = errors.Wrap(e, "failed to close step log file")
+		}
+		if err != nil {
+			if logFile != "" {
+				l.Printf("failed in %s (output in %s)", hash, logFile)
+			} else {
+				l.Printf("failed in %s", hash)
+			}
 			pkg.state[i] = "-" + hash
 			pkg.state = pkg.state[:i+1]
 			return err
 		}
 		l.Printf("executed %s", hash)
+
+		if interruptsConnection(s) {
+			// the connection was re-established, so the step is persisted
+			// right away. If the target didn't come back, the step failed
+			// and is executed again in the next run.
+			pkg.state[i] = "+" + hash
+			if err = pkg.writeTargetState(client, pkg.state[:i+1]); err != nil {
+				return err
+			}
+		}
+
+		// the script might have changed during execution (like captured
+		// values), so the following scripts must be prepared again.
+		if newHash := s.Hash(); newHash != hash {
+			l.Printf("hash changed to %s, preparing following steps", newHash)
+			if err = pkg.prepareScripts(i+1, newHash); err != nil {
+				pkg.state[i] = "-" + hash
+				pkg.state = pkg.state[:i+1]
+				return err
+			}
+			hash = newHash
+		}
 		pkg.state[i] = "+" + hash
+		pkg.notifyHandlers(i)
 	}
-	return nil
+	return pkg.runHandlers(l, client)
+}
+
+// stepLogFile returns the name of the file the output of the step with the
+// given index is logged to. It's empty if no log directory is configured.
+func (pkg *smPackage) stepLogFile(idx int) string {
+	if pkg.logDir == "" {
+		return ""
+	}
+	return filepath.Join(pkg.logDir, fmt.Sprintf("%s_%02d.log", pkg.ID, idx))
+}
+
+// stepBecome returns the user the package's steps are run as, if it differs
+// from the one of the resource's commands (e.g. the state handling). Otherwise
+// nil is returned.
+func (pkg *smPackage) stepBecome() *becomeConfig {
+	if _, ok := pkg.Attributes["Become"]; !ok || pkg.become.equal(pkg.resBecome) {
+		return nil
+	}
+	if pkg.become == nil {
+		return &becomeConfig{User: pkg.loginUser, Method: pkg.resBecome.Method}
+	}
+	return pkg.become
+}
+
+// execStep executes the script with the given index. Failed executions are
+// retried, if the package is configured accordingly.
+func (pkg *smPackage) execStep(l *log.Logger, client gconn.Client, idx int, start time.Time) (err error) {
+	s := pkg.Scripts[idx]
+
+	// The environment is reset when switching the user, so the run
+	// directory must be set again.
+	if become := pkg.stepBecome(); become != nil {
+		client = newBecomeClient(client, become)
+		if pkg.runDir != "" {
+			client = newRunDirClient(client, pkg.runDir)
+		}
+	}
+
+	delay := pkg.retryDelay
+	for attempt := 0; ; attempt++ {
+		// The output of an attempt is kept, so that it can be shown with
+		// the reason for a retry.
+		tail := newTailWriter(retryOutputLines)
+		al := log.New(io.MultiWriter(l.Writer(), tail), l.Prefix(), l.Flags())
+
+		err = s.Exec(al, newDeadlineClient(client, pkg.deadline(start)))
+		if errors.Cause(err) == errTimeout {
+			err = errors.Errorf("step %d exceeded the timeout", idx)
+		}
+
+		if err == nil || attempt >= pkg.retries {
+			return pkg.stepPos(idx).Wrap(err)
+		}
+
+		l.Printf("attempt %d of %d failed: %s", attempt+1, pkg.retries+1, err)
+		if lines := tail.Lines(); len(lines) > 0 {
+			l.Printf("last output of the failed attempt:")
+			for _, line := range lines {
+				l.Printf("  %s", line)
+			}
+		}
+		l.Printf("retrying in %s", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// stateClient returns the client used to handle the package's caching state.
+// If the resource doesn't set a user, but the package's steps are run as root,
+// the state is handled as root, too, as the login user might not be allowed to
+// write it.
+func (pkg *smPackage) stateClient(client gconn.Client) gconn.Client {
+	if pkg.resBecome == nil && pkg.become != nil && pkg.become.User == "root" {
+		return newBecomeClient(client, pkg.become)
+	}
+	return client
 }
 
 func (pkg *smPackage) readPackageState(client gconn.Client) ([]string, error) {
+	client = pkg.stateClient(client)
 	fname := fmt.Sprintf("/var/lib/smutje/%s.log", pkg.ID)
 	cmd := fmt.Sprintf(`if [[ -f '%[1]s' ]]; then cat %[1]s; else mkdir -p /var/lib/smutje; fi`, fname)
 
@@ -193,12 +470,13 @@ func (pkg *smPackage) readPackageState(client gconn.Client) ([]string, error) {
 	return state, errors.Wrap(sc.Err(), "failed to scan output")
 }
 
-func (pkg *smPackage) writeTargetState(client gconn.Client) error {
+func (pkg *smPackage) writeTargetState(client gconn.Client, state []string) error {
+	client = pkg.stateClient(client)
 	tstamp := time.Now().UTC().Format("20060102T150405")
 	filename := fmt.Sprintf("/var/lib/smutje/%s.%s.log", pkg.ID, tstamp)
-	cmd := fmt.Sprintf(`rm -Rf /tmp/smutje/*; cat - > %[1]s && ln -sf %[1]s /var/lib/smutje/%[2]s.log`, filename, pkg.ID)
+	cmd := fmt.Sprintf(`cat - > %[1]s && ln -sf %[1]s /var/lib/smutje/%[2]s.log`, filename, pkg.ID)
 
-	sess, err := client.NewSession("/usr/bin/env", "bash", "-c", fmt.Sprintf("%q", cmd))
+	sess, err := client.NewSession("/usr/bin/env", "bash", "-c", shellQuote(cmd))
 	if err != nil {
 		return err
 	}
@@ -213,7 +491,7 @@ func (pkg *smPackage) writeTargetState(client gconn.Client) error {
 		return err
 	}
 
-	if _, err := io.WriteString(stdin, strings.Join(pkg.state, "\n")+"\n"); err != nil {
+	if _, err := io.WriteString(stdin, strings.Join(state, "\n")+"\n"); err != nil {
 		return errors.Wrap(err, "failed to send script to target")
 	}
 	stdin.Close()
@@ -103,16 +103,154 @@ func TestProvision(t *testing.T) {
 	}
 }
 
+func TestProvisionRetries(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+
+	tt := []struct {
+		retries  string
+		expErr   bool
+		expState []string
+	}{
+		{"0", true, []string{hAE, hBF}},
+		{"1", false, []string{hAE, hBE, hCE}},
+	}
+
+	for i, tti := range tt {
+		client := new(testClient)
+		client.failIdx = -1
+
+		pkg := new(smPackage)
+		pkg.ID = "foobar"
+		pkg.Attributes = Attributes{"Retries": tti.retries, "RetryDelay": "1ms"}
+		pkg.Scripts = []smScript{
+			&bashScript{Script: "echo foo"},
+			&smutjeScript{rawCommand: ":write_file testdata/a b"},
+			&bashScript{Script: "echo bar"},
+		}
+
+		if err := pkg.Prepare(client, Attributes{}); err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+
+		client.curIdx = 0
+		client.failIdx = 1
+
+		err := pkg.Provision(l, client)
+		if tti.expErr && err == nil {
+			t.Errorf("%d: expected an error, got none", i)
+		} else if !tti.expErr && err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		}
+
+		if strings.Join(pkg.state, " ") != strings.Join(tti.expState, " ") {
+			t.Errorf("%d: expected state %q, got %q", i, tti.expState, pkg.state)
+		}
+	}
+}
+
+// flakyCmd writes output and fails on the first execution.
+type flakyCmd struct {
+	testCmd
+}
+
+func (c *flakyCmd) Exec(l *log.Logger, client gconn.Client) error {
+	c.execs++
+	l.Printf("%s attempt %d", stdoutTag, c.execs)
+	if c.execs == 1 {
+		return errors.Errorf("asked to fail")
+	}
+	return nil
+}
+
+func TestProvisionRetryOutput(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	oldOutput := logOutput
+	logOutput = buf
+	defer func() { logOutput = oldOutput }()
+	l := log.New(ioutil.Discard, "", 0)
+
+	client := new(testClient)
+	client.failIdx = -1
+
+	pkg := new(smPackage)
+	pkg.ID = "foobar"
+	pkg.Attributes = Attributes{"Retries": "1", "RetryDelay": "1ms"}
+	pkg.Scripts = []smScript{&flakyCmd{}}
+
+	if err := pkg.Prepare(client, Attributes{}); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if err := pkg.Provision(l, client); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	exp := "attempt 1 of 2 failed: asked to fail\nfoobar step0 last output of the failed attempt:\nfoobar step0   out | attempt 1\n"
+	if !strings.Contains(buf.String(), exp) {
+		t.Errorf("expected log to contain %q, got:\n%s", exp, buf.String())
+	}
+}
+
+// rebootCmd simulates a reboot, after which the target doesn't come back.
+type rebootCmd struct {
+	testCmd
+}
+
+func (c *rebootCmd) Exec(l *log.Logger, client gconn.Client) error {
+	c.execs++
+	rc, _ := findReconnectingClient(client)
+	rc.Client = nil
+	return errors.Errorf("target not reachable")
+}
+
+func (*rebootCmd) InterruptsConnection() bool { return true }
+
+func TestProvisionReconnectFailure(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+
+	tc := new(testClient)
+	tc.failIdx = -1
+	client := newReconnectingClient(tc, nil)
+
+	pkg := new(smPackage)
+	pkg.ID = "foobar"
+	pkg.Scripts = []smScript{
+		&bashScript{Script: "echo foo"},
+		&rebootCmd{},
+		&bashScript{Script: "echo bar"},
+	}
+
+	if err := pkg.Prepare(client, Attributes{}); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	if err := pkg.Provision(l, client); err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+
+	if exp := []string{hAE, "-" + hA + "test"}; strings.Join(pkg.state, " ") != strings.Join(exp, " ") {
+		t.Errorf("expected state %q, got %q", exp, pkg.state)
+	}
+
+	for _, s := range tc.sessions {
+		if s.Stdin != nil && strings.Contains(s.Stdin.String(), "+"+hA+"test") {
+			t.Errorf("expected the reboot step not to be persisted as executed")
+		}
+	}
+}
+
 type testClient struct {
 	failIdx int
 	curIdx  int
 
 	expCommand string
 	cmdOutput  string
+
+	sessions []*testSession
 }
 
 func (tc *testClient) NewSession(cmd string, args ...string) (gconn.Session, error) {
 	s := new(testSession)
+	s.command = strings.Join(append([]string{cmd}, args...), " ")
 
 	if tc.expCommand != "" {
 		if strings.Contains(cmd, tc.expCommand) || strings.Contains(strings.Join(args, " "), tc.expCommand) {
@@ -126,6 +264,7 @@ func (tc *testClient) NewSession(cmd string, args ...string) (gconn.Session, err
 		s.fail = true
 	}
 	tc.curIdx++
+	tc.sessions = append(tc.sessions, s)
 	return s, nil
 }
 
@@ -142,7 +281,8 @@ type testSession struct {
 	Stdout *bytes.Buffer
 	Stderr *bytes.Buffer
 
-	fail bool
+	fail    bool
+	command string
 
 	expCommand string
 	cmdOutput  string
@@ -25,6 +25,8 @@ func (a astNodeType) String() string {
 		return "ScriptNode"
 	case AstAttributes:
 		return "AttributesNode"
+	case AstHandler:
+		return "Handler"
 	default:
 		panic("shouldn't be called")
 	}
@@ -39,6 +41,7 @@ const (
 	AstText
 	AstScript
 	AstAttributes
+	AstHandler
 )
 
 type AstNode struct {
@@ -46,9 +49,14 @@ type AstNode struct {
 
 	Name string
 	ID   string
+	Pos  Pos
 
 	Type  astNodeType
 	Value interface{}
+
+	// Fence is set for nodes of fenced code blocks. Nodes of the same block
+	// share the fence.
+	Fence *Fence
 }
 
 func (n *AstNode) addChild(child *AstNode) {
@@ -58,25 +66,18 @@ func (n *AstNode) addChild(child *AstNode) {
 func (n *AstNode) String() string {
 	switch n.Type {
 	case AstResource, AstTemplate:
-		content := fmt.Sprintf("# %s: %s [%s]\n\n", n.Type, n.Name, n.ID)
-		for i, child := range n.Children {
-			if i > 0 {
-				content += "\n"
-			}
-			content += child.String()
-		}
-		return content
-	case AstPackage, AstInclude, AstBlueprint:
-		content := fmt.Sprintf("\n## %s: %s [%s]\n\n", n.Type, n.Name, n.ID)
-		for i, child := range n.Children {
-			if i > 0 {
-				content += "\n"
-			}
-			content += child.String()
-		}
-		return content
+		return fmt.Sprintf("# %s: %s [%s]\n\n", n.Type, n.Name, n.ID) + n.childrenString()
+	case AstPackage, AstInclude, AstBlueprint, AstHandler:
+		return fmt.Sprintf("\n## %s: %s [%s]\n\n", n.Type, n.Name, n.ID) + n.childrenString()
 	case AstText:
-		return n.Value.(string)
+		switch v := n.Value.(type) {
+		case string:
+			return v
+		case []string:
+			return strings.Join(v, "\n") + "\n"
+		default:
+			panic(fmt.Sprintf("unknown text type: %T", n.Value))
+		}
 	case AstAttributes:
 		content := ""
 		for _, attr := range n.Value.([]*Attribute) {
@@ -85,6 +86,9 @@ func (n *AstNode) String() string {
 		return content
 	case AstScript:
 		indent := "    "
+		if n.Fence != nil {
+			indent = ""
+		}
 		switch s := n.Value.(type) {
 		case *SmutjeScript:
 			return s.IndentedString(indent)
@@ -98,6 +102,26 @@ func (n *AstNode) String() string {
 	}
 }
 
+// childrenString returns the children separated by empty lines. Consecutive
+// nodes of a fenced code block are put in a single block again.
+func (n *AstNode) childrenString() string {
+	content := ""
+	for i, child := range n.Children {
+		sameBlock := i > 0 && child.Fence != nil && child.Fence == n.Children[i-1].Fence
+		if i > 0 && !sameBlock {
+			content += "\n"
+		}
+		if child.Fence != nil && !sameBlock {
+			content += fenceDelim + child.Fence.Lang + "\n"
+		}
+		content += child.String()
+		if child.Fence != nil && (i == len(n.Children)-1 || n.Children[i+1].Fence != child.Fence) {
+			content += fenceDelim + "\n"
+		}
+	}
+	return content
+}
+
 type Attribute struct {
 	Key string
 	Val string
@@ -105,6 +129,8 @@ type Attribute struct {
 
 type BashScript struct {
 	Script string
+	// Lang is the language of a fenced code block, if it isn't bash.
+	Lang string
 }
 
 func (bs *BashScript) IndentedString(indent string) string {
@@ -135,6 +161,8 @@ func mapTyp(typ string) astNodeType {
 		return AstInclude
 	case "template":
 		return AstTemplate
+	case "handler":
+		return AstHandler
 	default:
 		return -1
 	}
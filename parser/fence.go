@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const fenceDelim = "```"
+
+// A Fence describes a fenced code block, i.e. a block delimited by lines of
+// three backticks. The language given after the opening backticks selects how
+// the block is treated.
+type Fence struct {
+	Lang string
+}
+
+// Languages of fenced code blocks. Blocks without language are handled like
+// indented code blocks.
+const (
+	LangBash   = "bash"
+	LangPython = "python"
+	LangJSON   = "json"
+	LangText   = "text"
+)
+
+func normalizeLang(lang string) (string, bool) {
+	switch lang {
+	case "", "bash", "sh", "shell":
+		return LangBash, true
+	case "python", "python3", "py":
+		return LangPython, true
+	case "json":
+		return LangJSON, true
+	case "text", "txt", "plain":
+		return LangText, true
+	default:
+		return "", false
+	}
+}
+
+type fencedBlock struct {
+	fence *Fence
+	lines []string
+}
+
+// extractFences replaces the fenced code blocks of the input with indented
+// ones, so that gmd can parse it. Line numbers are retained. The blocks are
+// returned keyed by the number of their first line, as the content is restored
+// from the original lines afterwards.
+func extractFences(name, input string) (string, map[int]*fencedBlock, error) {
+	lines := strings.Split(input, "\n")
+	blocks := map[int]*fencedBlock{}
+
+	var cur *fencedBlock
+	open := 0
+	for i, line := range lines {
+		switch {
+		case cur == nil && strings.HasPrefix(line, fenceDelim):
+			lang := ""
+			if fields := strings.Fields(line[len(fenceDelim):]); len(fields) > 0 {
+				lang = strings.ToLower(fields[0])
+			}
+			cur, open = &fencedBlock{fence: &Fence{Lang: lang}}, i
+			lines[i] = ""
+		case cur == nil:
+			// not inside a fenced block
+		case strings.TrimRight(line, " \t\r") == fenceDelim:
+			if len(cur.lines) > 0 {
+				blocks[open+2] = cur
+			}
+			cur = nil
+			lines[i] = ""
+		default:
+			cur.lines = append(cur.lines, line)
+			lines[i] = "    " + line
+		}
+	}
+
+	if cur != nil {
+		return "", nil, errors.Errorf("%s:%d: unterminated code block", name, open+1)
+	}
+	return strings.Join(lines, "\n"), blocks, nil
+}
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"io/ioutil"
 	"regexp"
 	"strings"
 
@@ -9,28 +10,43 @@ import (
 )
 
 func ParseString(name, template string) (*AstNode, error) {
+	input, blocks, err := extractFences(name, template)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse template %q", name)
+	}
+
 	// generalized AST, that we need to pimp to match our style.
-	rAst, err := gmd.ParseString(name, template)
+	rAst, err := gmd.ParseString(name, input)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse template %q", name)
 	}
 
-	return convertSection(rAst)
+	return convertSection(rAst, blocks)
 }
 
 func Parse(filename string) (*AstNode, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse file")
+	}
+
+	input, blocks, err := extractFences(filename, string(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse file")
+	}
+
 	// generalized AST, that we need to pimp to match our style.
-	rAst, err := gmd.Parse(filename)
+	rAst, err := gmd.ParseString(filename, input)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse file")
 	}
 
-	return convertSection(rAst)
+	return convertSection(rAst, blocks)
 }
 
 var reTitle = regexp.MustCompile(`^\#+ (\w+):[ ]*(.*)[ ]*\[(\w+)\]$`)
 
-func convertSection(raw *gmd.AstNode) (*AstNode, error) {
+func convertSection(raw *gmd.AstNode, blocks map[int]*fencedBlock) (*AstNode, error) {
 	if len(raw.Lines) != 1 {
 		return nil, raw.Errorf(0, "section title must be a single line")
 	}
@@ -44,18 +60,19 @@ func convertSection(raw *gmd.AstNode) (*AstNode, error) {
 	n := &AstNode{
 		Type: mapTyp(m[1]),
 		Name: strings.TrimSpace(m[2]), ID: m[3],
+		Pos: pos(raw, 0),
 	}
 
 	for _, child := range raw.Children {
 		switch child.Type {
 		case gmd.AstSection:
-			c, err := convertSection(child)
+			c, err := convertSection(child, blocks)
 			if err != nil {
 				return nil, err
 			}
 			n.Children = append(n.Children, c)
 		case gmd.AstCode:
-			cs, err := convertCode(child)
+			cs, err := convertCode(child, blocks[child.LineNo])
 			if err != nil {
 				return nil, err
 			}
@@ -80,35 +97,69 @@ func convertSection(raw *gmd.AstNode) (*AstNode, error) {
 	return n, nil
 }
 
-func convertCode(raw *gmd.AstNode) ([]*AstNode, error) {
+// convertCode converts a code block. For fenced blocks the block's original
+// lines are used and the language selects how the block is treated: bash
+// blocks are handled like indented ones, python and json blocks are a single
+// script each, and text blocks aren't executed at all.
+func convertCode(raw *gmd.AstNode, block *fencedBlock) ([]*AstNode, error) {
+	if block == nil {
+		return convertScripts(raw, raw.Lines, nil), nil
+	}
+
+	lang, ok := normalizeLang(block.fence.Lang)
+	switch {
+	case !ok:
+		// the opening fence is in the line before the block
+		return nil, raw.Errorf(-1, "unsupported code block language %q (expected bash, sh, python, json or text)", block.fence.Lang)
+	case lang == LangBash:
+		return convertScripts(raw, block.lines, block.fence), nil
+	case lang == LangText:
+		return []*AstNode{{Type: AstText, Value: block.lines, Pos: pos(raw, 0), Fence: block.fence}}, nil
+	default:
+		return []*AstNode{{
+			Type:  AstScript,
+			Value: &BashScript{Script: strings.Join(block.lines, "\n"), Lang: lang},
+			Pos:   pos(raw, 0),
+			Fence: block.fence,
+		}}, nil
+	}
+}
+
+// convertScripts splits the lines into bash and smutje scripts. Lines
+// starting with a colon are smutje scripts.
+func convertScripts(raw *gmd.AstNode, lines []string, fence *Fence) []*AstNode {
 	nodes := []*AstNode{}
 
 	start := 0
-	for i, line := range raw.Lines {
+	for i, line := range lines {
 		if line != "" && line[0] == ':' {
-			nodes = appendScriptIfAny(nodes, raw.Lines, start, i)
+			nodes = appendScriptIfAny(nodes, raw, lines, fence, start, i)
 			nodes = append(nodes, &AstNode{
 				Type:  AstScript,
-				Value: &SmutjeScript{raw.Lines[i]},
+				Value: &SmutjeScript{lines[i]},
+				Pos:   pos(raw, i),
+				Fence: fence,
 			})
 			start = i + 1
 		}
 	}
-	return appendScriptIfAny(nodes, raw.Lines, start, len(raw.Lines)), nil
+	return appendScriptIfAny(nodes, raw, lines, fence, start, len(lines))
 }
 
-func appendScriptIfAny(nodes []*AstNode, lines []string, start, cur int) []*AstNode {
+func appendScriptIfAny(nodes []*AstNode, raw *gmd.AstNode, lines []string, fence *Fence, start, cur int) []*AstNode {
 	if cur > start {
 		return append(nodes, &AstNode{
 			Type:  AstScript,
-			Value: &BashScript{strings.Join(lines[start:cur], "\n")},
+			Value: &BashScript{Script: strings.Join(lines[start:cur], "\n")},
+			Pos:   pos(raw, start),
+			Fence: fence,
 		})
 	}
 	return nodes
 }
 
 func convertText(raw *gmd.AstNode) (*AstNode, error) {
-	return &AstNode{Type: AstText, Value: raw.Lines}, nil
+	return &AstNode{Type: AstText, Value: raw.Lines, Pos: pos(raw, 0)}, nil
 }
 
 var reQuote = regexp.MustCompile(`^(\w+):[ ]*(.*)[ ]*$`)
@@ -122,5 +173,10 @@ func convertQuote(raw *gmd.AstNode) (*AstNode, error) {
 		}
 		attrs = append(attrs, &Attribute{m[1], m[2]})
 	}
-	return &AstNode{Type: AstAttributes, Value: attrs}, nil
+	return &AstNode{Type: AstAttributes, Value: attrs, Pos: pos(raw, 0)}, nil
+}
+
+// pos returns the position of the raw node's i-th line.
+func pos(raw *gmd.AstNode, i int) Pos {
+	return Pos{File: raw.File, Line: raw.LineNo + i}
 }
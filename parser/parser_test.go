@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -77,3 +78,141 @@ func TestParser_Happy(t *testing.T) {
 		}
 	}
 }
+
+func TestParser_Positions(t *testing.T) {
+	node, err := Parse("testdata/test_base.smd")
+	if err != nil {
+		t.Fatalf("didn't expect an error parsing the testdata, got: %s", err)
+	}
+
+	tt := []struct {
+		node *AstNode
+		exp  string
+	}{
+		{node, "testdata/test_base.smd:1"},
+		{node.Children[1], "testdata/test_base.smd:5"},
+		{node.Children[3], "testdata/test_base.smd:11"},
+		{node.Children[3].Children[2], "testdata/test_base.smd:18"},
+		{node.Children[3].Children[4], "testdata/test_base.smd:23"},
+		{node.Children[3].Children[5], "testdata/test_base.smd:24"},
+		{node.Children[4].Children[1], "testdata/test_base.smd:31"},
+		{node.Children[5], "testdata/test_base.smd:36"},
+	}
+
+	for i, tti := range tt {
+		if got := tti.node.Pos.String(); got != tti.exp {
+			t.Errorf("%d: expected position %q, got %q", i, tti.exp, got)
+		}
+	}
+}
+
+func TestPos_Wrap(t *testing.T) {
+	res := Pos{File: "res.smd", Line: 12}
+	tmpl := Pos{File: "a.smd", Line: 3, IncludedFrom: &res}
+	pos := Pos{File: "b.smd", Line: 5, IncludedFrom: &tmpl}
+
+	tt := []struct {
+		err error
+		exp string
+	}{
+		{res.Wrap(errors.New("failed")), "res.smd:12: failed"},
+		{pos.Wrap(errors.New("failed")), "b.smd:5: failed (included from a.smd:3, res.smd:12)"},
+		{res.Wrap(pos.Wrap(errors.New("failed"))), "b.smd:5: failed (included from a.smd:3, res.smd:12)"},
+		{Pos{}.Wrap(errors.New("failed")), "failed"},
+	}
+
+	for i, tti := range tt {
+		if got := tti.err.Error(); got != tti.exp {
+			t.Errorf("%d: expected error %q, got %q", i, tti.exp, got)
+		}
+	}
+
+	if res.Wrap(nil) != nil {
+		t.Errorf("expected nil error to stay nil")
+	}
+}
+
+func TestParser_Fenced(t *testing.T) {
+	node, err := Parse("testdata/test_fenced.smd")
+	if err != nil {
+		t.Fatalf("didn't expect an error parsing the testdata, got: %s", err)
+	}
+
+	bp, pkg := node.Children[0], node.Children[1]
+	if len(bp.Children) != 1 || len(pkg.Children) != 6 {
+		t.Fatalf("expected 1 blueprint and 6 package children, got %d and %d", len(bp.Children), len(pkg.Children))
+	}
+
+	tt := []struct {
+		node   *AstNode
+		typ    astNodeType
+		value  string
+		lang   string
+		pos    string
+		fenced bool
+	}{
+		{bp.Children[0], AstScript, "{\n  \"brand\": \"joyent\"\n}", LangJSON, "testdata/test_fenced.smd:6", true},
+		{pkg.Children[0], AstScript, "echo one", "", "testdata/test_fenced.smd:14", true},
+		{pkg.Children[1], AstScript, ":write_file hosts /etc/hosts", "", "testdata/test_fenced.smd:15", true},
+		{pkg.Children[2], AstScript, "# not a title\necho two", "", "testdata/test_fenced.smd:16", true},
+		{pkg.Children[3], AstScript, "print(\"hello\")", LangPython, "testdata/test_fenced.smd:21", true},
+		{pkg.Children[4], AstText, "rm -rf /", "", "testdata/test_fenced.smd:25", true},
+		{pkg.Children[5], AstScript, "echo indented", "", "testdata/test_fenced.smd:28", false},
+	}
+
+	for i, tti := range tt {
+		value, lang := "", ""
+		switch v := tti.node.Value.(type) {
+		case *BashScript:
+			value, lang = v.Script, v.Lang
+		case *SmutjeScript:
+			value = v.Command
+		case []string:
+			value = strings.Join(v, "\n")
+		}
+
+		switch {
+		case tti.node.Type != tti.typ:
+			t.Errorf("%d: expected type %s, got %s", i, tti.typ, tti.node.Type)
+		case value != tti.value:
+			t.Errorf("%d: expected value %q, got %q", i, tti.value, value)
+		case lang != tti.lang:
+			t.Errorf("%d: expected language %q, got %q", i, tti.lang, lang)
+		case tti.node.Pos.String() != tti.pos:
+			t.Errorf("%d: expected position %q, got %q", i, tti.pos, tti.node.Pos)
+		case (tti.node.Fence != nil) != tti.fenced:
+			t.Errorf("%d: expected fenced to be %t", i, tti.fenced)
+		}
+	}
+
+	if pkg.Children[0].Fence != pkg.Children[2].Fence {
+		t.Errorf("expected the scripts of a fenced block to share the fence")
+	}
+
+	again, err := ParseString("testdata/test_fenced.smd", node.String())
+	if err != nil {
+		t.Fatalf("didn't expect an error parsing the formatted testdata, got: %s", err)
+	}
+	if again.String() != node.String() {
+		t.Errorf("expected formatting to be stable, got:\n%s", again)
+	}
+}
+
+func TestParser_FencedFailure(t *testing.T) {
+	tt := []struct {
+		input string
+		err   string
+	}{
+		{"# Resource: a [a]\n\n```bash\necho foo\n", `stdin:3: unterminated code block`},
+		{"# Resource: a [a]\n\n```ruby\nputs 'foo'\n```\n", `stdin:3: unsupported code block language "ruby" (expected bash, sh, python, json or text)`},
+	}
+
+	for _, tti := range tt {
+		_, err := ParseString("stdin", tti.input)
+		if err == nil {
+			t.Errorf("expected error %q, got none", tti.err)
+		} else if !strings.HasSuffix(err.Error(), tti.err) {
+			t.Errorf("expected error %q, got %q", tti.err, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pos is the position of a node in a smd file. If the file was included, the
+// position of the include node is given, too.
+type Pos struct {
+	File string
+	Line int
+
+	IncludedFrom *Pos
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// IsValid returns whether the position was set.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// Includes returns the positions of the includes leading to the position, the
+// innermost first.
+func (p Pos) Includes() []Pos {
+	incs := []Pos{}
+	for inc := p.IncludedFrom; inc != nil; inc = inc.IncludedFrom {
+		incs = append(incs, *inc)
+	}
+	return incs
+}
+
+// Wrap annotates the error with the position, i.e. the error's message is
+// prefixed with `file:line:` and the include chain is appended. Errors that
+// already carry a position are returned unchanged, as the innermost position
+// is the most precise one.
+func (p Pos) Wrap(err error) error {
+	if err == nil || !p.IsValid() {
+		return err
+	}
+	var pe *PosError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &PosError{Pos: p, Err: err}
+}
+
+// A PosError is an error annotated with the position it relates to.
+type PosError struct {
+	Pos Pos
+	Err error
+}
+
+func (e *PosError) Error() string {
+	msg := e.Pos.String() + ": " + e.Err.Error()
+	if incs := e.Pos.Includes(); len(incs) > 0 {
+		names := make([]string, len(incs))
+		for i, inc := range incs {
+			names[i] = inc.String()
+		}
+		msg += " (included from " + strings.Join(names, ", ") + ")"
+	}
+	return msg
+}
+
+func (e *PosError) Cause() error {
+	return e.Err
+}
+
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
+
+// SetIncludedFrom marks the node and all its children as included from the
+// given position.
+func (n *AstNode) SetIncludedFrom(inc Pos) {
+	n.Pos.IncludedFrom = &inc
+	for _, child := range n.Children {
+		child.SetIncludedFrom(inc)
+	}
+}
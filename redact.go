@@ -0,0 +1,133 @@
+package smutje
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const redactedSecret = "*****"
+
+var (
+	trackedSecretsMu sync.RWMutex
+	trackedSecrets   []string
+)
+
+// trackSecret registers the given value as secret, so that it is masked in
+// all log output and error messages.
+func trackSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	// The quoted form differs if the value contains characters that must be
+	// escaped.
+	quoted := strconv.Quote(value)
+	quoted = quoted[1 : len(quoted)-1]
+
+	trackedSecretsMu.Lock()
+	defer trackedSecretsMu.Unlock()
+
+	for _, v := range []string{value, quoted} {
+		found := false
+		for _, s := range trackedSecrets {
+			found = found || s == v
+		}
+		if !found {
+			trackedSecrets = append(trackedSecrets, v)
+		}
+	}
+
+	// replace longer secrets first, so that secrets containing others are
+	// masked completely.
+	sort.Slice(trackedSecrets, func(i, j int) bool {
+		return len(trackedSecrets[i]) > len(trackedSecrets[j])
+	})
+}
+
+// redact masks all tracked secrets in the given string.
+func redact(s string) string {
+	trackedSecretsMu.RLock()
+	defer trackedSecretsMu.RUnlock()
+
+	for _, secret := range trackedSecrets {
+		s = strings.Replace(s, secret, redactedSecret, -1)
+	}
+	return s
+}
+
+// redactError masks all tracked secrets in the error's message.
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := err.Error(); redact(msg) != msg {
+		return errors.New(redact(msg))
+	}
+	return err
+}
+
+// redactingWriter masks all tracked secrets in the data written. Loggers
+// write a complete message at once, so secrets are not split across writes.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func newRedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// injectedSecret returns the name of the attribute containing the shell
+// expression reading the injected password, if the given attribute is one of
+// its values (`PASSWORD_<name>_RAW` or `PASSWORD_<name>_QUOTED`).
+func (a Attributes) injectedSecret(k string) (string, bool) {
+	if !strings.HasPrefix(k, "PASSWORD_") {
+		return "", false
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(k, "_RAW"), "_QUOTED")
+	return base, base != k && a[base] != ""
+}
+
+// indirectSecrets returns a copy of the attributes, with the values of the
+// injected passwords (`PASSWORD_<name>_RAW` and `PASSWORD_<name>_QUOTED`)
+// replaced by the shell expression reading them on the target. This is used
+// for bash scripts persisted on the target.
+func (a Attributes) indirectSecrets() Attributes {
+	c := a.Copy()
+	for k := range a {
+		switch base, ok := a.injectedSecret(k); {
+		case !ok:
+			// not an injected password
+		case strings.HasSuffix(k, "_RAW"):
+			c[k] = a[base]
+		default:
+			c[k] = `"` + a[base] + `"`
+		}
+	}
+	return c
+}
+
+// withoutSecrets returns a copy of the attributes without the injected
+// passwords. This is used for scripts of other interpreters persisted on the
+// target, as there is no shell to read them indirectly.
+func (a Attributes) withoutSecrets() Attributes {
+	c := a.Copy()
+	for k := range a {
+		if base, ok := a.injectedSecret(k); ok {
+			delete(c, k)
+			delete(c, base)
+		}
+	}
+	return c
+}
@@ -0,0 +1,123 @@
+package smutje
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRedact(t *testing.T) {
+	trackSecret("s3cr3t-redact")
+	trackSecret(`quo"ted-redact`)
+	trackSecret("")
+
+	buf := bytes.NewBuffer(nil)
+	l := log.New(newRedactingWriter(buf), "", 0)
+	l.Printf("password is s3cr3t-redact")
+
+	tt := []struct {
+		got string
+		exp string
+		msg string
+	}{
+		{redact("echo s3cr3t-redact"), "echo *****", "tracked secret is masked"},
+		{redact(`echo "quo\"ted-redact"`), `echo "*****"`, "quoted form is masked"},
+		{redact("nothing to hide"), "nothing to hide", "other text is unchanged"},
+		{buf.String(), "password is *****\n", "log output is masked"},
+		{redactError(errors.New("failed with s3cr3t-redact")).Error(), "failed with *****", "errors are masked"},
+	}
+
+	for _, tc := range tt {
+		if tc.got != tc.exp {
+			t.Errorf("%s: expected %q, got %q", tc.msg, tc.exp, tc.got)
+		}
+	}
+
+	if redactError(nil) != nil {
+		t.Errorf("expected nil error to stay nil")
+	}
+}
+
+func TestIndirectSecrets(t *testing.T) {
+	attrs := Attributes{
+		"PASSWORD_db":        "$(read db)",
+		"PASSWORD_db_RAW":    "plain-indirect",
+		"PASSWORD_db_QUOTED": `"plain-indirect"`,
+		"PASSWORD_other_RAW": "kept",
+		"Other":              "value",
+	}
+
+	s := &bashScript{ID: "test", Script: "echo {{ .PASSWORD_db_RAW }} {{ .PASSWORD_db_QUOTED }} {{ .PASSWORD_other_RAW }} {{ .Other }}"}
+	if _, err := s.Prepare(attrs, ""); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	if strings.Contains(s.script, "plain-indirect") {
+		t.Errorf("expected secret not to be contained in script, got %q", s.script)
+	}
+
+	exp := `echo $(read db) "$(read db)" kept value`
+	if !strings.Contains(s.script, exp) {
+		t.Errorf("expected script to contain %q, got %q", exp, s.script)
+	}
+
+	if attrs["PASSWORD_db_RAW"] != "plain-indirect" {
+		t.Errorf("expected attributes not to be modified")
+	}
+}
+
+func TestInterpreterSecrets(t *testing.T) {
+	attrs := Attributes{
+		"PASSWORD_db":        "$(read db)",
+		"PASSWORD_db_RAW":    "plain-indirect",
+		"PASSWORD_db_QUOTED": `"plain-indirect"`,
+		"Other":              "value",
+	}
+
+	s := &bashScript{ID: "test", Lang: "python", Script: "print('{{ .Other }}')"}
+	if _, err := s.Prepare(attrs, ""); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	for _, script := range []string{
+		"#!/usr/bin/env python3\nprint({{ .PASSWORD_db_QUOTED }})",
+		"print('{{ .PASSWORD_db_RAW }}')",
+		"print('{{ .PASSWORD_db }}')",
+	} {
+		s := &bashScript{ID: "test", Lang: "python", Script: script}
+		_, err := s.Prepare(attrs, "")
+		if err == nil || !strings.Contains(err.Error(), "SMUTJE_PASSWORD_") {
+			t.Errorf("expected an error pointing to the environment for %q, got: %v", script, err)
+		}
+	}
+}
+
+func TestExportSecretsCmd(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "passwords"), []byte("db.admin\tit's $a secret\n"), 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	cmd := exec.Command("bash", "-c", exportSecretsCmd+`; printf %s "$SMUTJE_PASSWORD_db_admin"`)
+	cmd.Env = append(os.Environ(), "SMUTJE_RUN_DIR="+dir)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if exp := "it's $a secret"; string(out) != exp {
+		t.Errorf("expected %q, got %q", exp, out)
+	}
+
+	cmd = exec.Command("bash", "-c", exportSecretsCmd+`; echo done`)
+	cmd.Env = append(os.Environ(), "SMUTJE_RUN_DIR="+filepath.Join(dir, "missing"))
+	if out, err := cmd.Output(); err != nil || string(out) != "done\n" {
+		t.Errorf("expected a missing passwords file to be ignored, got %q (%v)", out, err)
+	}
+}
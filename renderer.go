@@ -15,8 +15,12 @@ func renderString(context, input string, attrs Attributes) (string, error) {
 		return "", errors.Wrap(err, "failed to parse template")
 	}
 	tmpl.Option("missingkey=error")
+	data, err := templateData(attrs)
+	if err != nil {
+		return "", err
+	}
 	buf := bytes.NewBuffer(nil)
-	err = tmpl.Execute(buf, attrs)
+	err = tmpl.Execute(buf, data)
 	return buf.String(), errors.Wrap(err, "failed to render template")
 }
 
@@ -26,7 +30,11 @@ func renderFile(filename string, attrs Attributes) (io.ReadCloser, error) {
 		return nil, errors.Wrap(err, "failed to parse template")
 	}
 	tpl.Option("missingkey=error")
+	data, err := templateData(attrs)
+	if err != nil {
+		return nil, err
+	}
 	buf := bytes.NewBuffer(nil)
-	err = tpl.Execute(buf, attrs)
+	err = tpl.Execute(buf, data)
 	return ioutil.NopCloser(buf), errors.Wrap(err, "failed to render template")
 }
@@ -5,6 +5,9 @@ import (
 	"log"
 
 	"net"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/gfrey/gconn"
 	"github.com/gfrey/smutje/hypervisor"
@@ -17,8 +20,19 @@ type Resource struct {
 	Name      string
 	Blueprint string
 
+	blueprintPos parser.Pos
+
 	Attributes Attributes
 	Packages   []*smPackage
+	Handlers   []*smPackage
+
+	// StepTimeout is the default for the maximum duration of a single step.
+	// It can be overwritten using the `StepTimeout` attribute.
+	StepTimeout time.Duration
+
+	// LogDir is the directory the output of the executed steps is logged to.
+	// For each run a subdirectory is created, containing a file per step.
+	LogDir string
 
 	client     gconn.Client
 	hypervisor hypervisor.Client
@@ -26,6 +40,7 @@ type Resource struct {
 
 	address  string
 	username string
+	become   *becomeConfig
 
 	isVirtual bool
 }
@@ -43,19 +58,25 @@ func NewResource(path string, n *parser.AstNode) (*Resource, error) {
 		case parser.AstBlueprint:
 			blueprint, err := newBlueprint(child)
 			if err != nil {
-				return nil, err
+				return nil, child.Pos.Wrap(err)
 			}
-			res.Blueprint = blueprint
+			res.Blueprint, res.blueprintPos = blueprint, child.Pos
 		default:
 			pkgs, err := handleChild("", path, res.Attributes, child)
 			if err != nil {
 				return nil, err
 			}
-			res.Packages = append(res.Packages, pkgs...)
+			for _, pkg := range pkgs {
+				if pkg.isHandler {
+					res.Handlers = append(res.Handlers, pkg)
+				} else {
+					res.Packages = append(res.Packages, pkg)
+				}
+			}
 		}
 	}
 
-	return res, nil
+	return res, resolveHandlers(res.Packages, res.Handlers)
 }
 
 func (res *Resource) Prepare(l *log.Logger) error {
@@ -65,10 +86,49 @@ func (res *Resource) Prepare(l *log.Logger) error {
 		return err
 	}
 
-	for _, pkg := range res.Packages {
-		if err := pkg.Prepare(res.client, res.Attributes); err != nil {
+	if res.client == nil {
+		// The virtual resource doesn't exist yet, so the packages are
+		// prepared after it was generated.
+		return nil
+	}
+	return res.prepareTarget(l)
+}
+
+// prepareTarget gathers the facts of the target and prepares the packages.
+func (res *Resource) prepareTarget(l *log.Logger) error {
+	switch enabled, err := gatherFactsEnabled(res.Attributes); {
+	case err != nil:
+		return err
+	case enabled:
+		// Facts must not silently replace the user's attributes.
+		if err := checkFactsAttributes(res.Attributes); err != nil {
 			return err
 		}
+		for _, pkgs := range [][]*smPackage{res.Packages, res.Handlers} {
+			for _, pkg := range pkgs {
+				if err := checkFactsAttributes(pkg.Attributes); err != nil {
+					return pkg.pos.Wrap(err)
+				}
+			}
+		}
+
+		facts, err := gatherFacts(l, res.client)
+		if err != nil {
+			return err
+		}
+		for k, v := range facts {
+			res.Attributes[k] = v
+		}
+	}
+
+	for _, pkgs := range [][]*smPackage{res.Packages, res.Handlers} {
+		for _, pkg := range pkgs {
+			pkg.stepTimeout = res.StepTimeout
+			pkg.loginUser, pkg.resBecome = res.username, res.become
+			if err := pkg.Prepare(res.client, res.Attributes); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -78,41 +138,182 @@ func (res *Resource) Generate(l *log.Logger) (err error) {
 		if res.uuid == "" {
 			res.Blueprint, err = renderString(res.ID+"/blueprint", res.Blueprint, res.Attributes)
 			if err != nil {
-				return err
+				return res.blueprintPos.Wrap(err)
 			}
 
 			res.uuid, err = res.hypervisor.Create(l, res.Blueprint)
 			if err != nil {
-				return err
+				return res.blueprintPos.Wrap(err)
 			}
 		}
 
-		res.client, err = res.hypervisor.ConnectVRes(res.uuid)
-		if err != nil {
+		if res.client, err = res.dial(); err != nil {
 			return err
 		}
+
+		if err := initializeTarget(res.client); err != nil {
+			return err
+		}
+		return res.prepareTarget(tagLogger(l, res.ID))
 	}
 
-	sess, err := res.client.NewSession("/usr/bin/env", "bash", "-c", `"mkdir -p /tmp/smutje && mkdir -p /var/lib/smutje"`)
+	return initializeTarget(res.client)
+}
+
+func (res *Resource) Provision(l *log.Logger) (err error) {
+	l = tagLogger(l, res.ID)
+
+	reconnecting := newReconnectingClient(res.client, res.connect)
+	defer func() { res.client = reconnecting.Client }()
+
+	// The resource's timeout limits the whole run (it is the default for
+	// the packages' timeout, too).
+	var deadline time.Time
+	if raw, ok := res.Attributes["Timeout"]; ok {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			return errors.Wrap(err, "invalid value for attribute Timeout")
+		}
+		deadline = time.Now().Add(timeout)
+	}
+	runDir, err := newRunDir(res.ID)
 	if err != nil {
 		return err
 	}
-	defer sess.Close()
+	for _, pkgs := range [][]*smPackage{res.Packages, res.Handlers} {
+		for _, pkg := range pkgs {
+			pkg.resDeadline, pkg.runDir = deadline, runDir
+		}
+	}
+	client, cleanup := withRunDir(l, reconnecting, runDir)
+	defer cleanup()
 
-	return sess.Run()
+	// Handlers notified by packages already provisioned are not run on
+	// errors, so the notifying steps must be executed again on the next run.
+	defer func() {
+		if err != nil {
+			res.invalidatePending(l, client)
+		}
+	}()
+
+	if res.LogDir != "" {
+		dir := filepath.Join(res.LogDir, res.ID+"_"+time.Now().UTC().Format("20060102T150405"))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.Wrap(err, "failed to create log directory")
+		}
+		l.Printf("logging output to %s", dir)
+
+		for _, pkgs := range [][]*smPackage{res.Packages, res.Handlers} {
+			for _, pkg := range pkgs {
+				pkg.logDir = dir
+			}
+		}
+	}
+
+	for i, pkg := range res.Packages {
+		exports := pkg.exports()
+		if err := pkg.Provision(l, client); err != nil {
+			return err
+		}
+
+		if changed := res.mergeExports(exports, pkg.exports()); changed {
+			// exported values changed during provisioning, so the following
+			// packages must be prepared again.
+			for _, pkgs := range [][]*smPackage{res.Packages[i+1:], res.Handlers} {
+				for _, p := range pkgs {
+					if err := p.Prepare(client, res.Attributes); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return res.runHandlers(l, client)
 }
 
-func (res *Resource) Provision(l *log.Logger) (err error) {
-	l = tagLogger(l, res.ID)
+// runHandlers runs the handlers notified by the packages, that run at the end
+// of the resource. Each handler is run once. If a handler fails, the notifying
+// steps are marked as failed (see invalidatePending), so that they are executed
+// again on the next run.
+func (res *Resource) runHandlers(l *log.Logger, client gconn.Client) error {
+	for _, h := range res.Handlers {
+		if !h.runAtResource {
+			continue
+		}
 
-	for _, pkg := range res.Packages {
-		if err := pkg.Provision(l, res.client); err != nil {
+		notified := []*smPackage{}
+		for _, pkg := range res.Packages {
+			if pkg.isNotified(h) {
+				notified = append(notified, pkg)
+			}
+		}
+		if len(notified) == 0 {
+			continue
+		}
+
+		if err := h.runHandler(l, client); err != nil {
 			return err
 		}
+		for _, pkg := range notified {
+			pkg.handlerRan(h)
+		}
 	}
 	return nil
 }
 
+// invalidatePending marks the steps notifying handlers, that were not run, as
+// failed and updates the packages' state on the target.
+func (res *Resource) invalidatePending(l *log.Logger, client gconn.Client) {
+	for _, pkg := range res.Packages {
+		if !pkg.invalidatePending() {
+			continue
+		}
+		if err := pkg.writeTargetState(client, pkg.state); err != nil {
+			l.Printf("failed to update state of %s: %s", pkg.ID, err)
+		}
+	}
+}
+
+// mergeExports sets the exported attributes in the resource's attributes and
+// returns whether they changed.
+func (res *Resource) mergeExports(old, cur Attributes) bool {
+	changed := false
+	for k, v := range cur {
+		if old[k] != v {
+			res.Attributes[k] = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// connect creates a new connection to the resource, the same way the initial
+// one was established. It is used to reconnect after the connection was lost,
+// e.g. on a reboot.
+func (res *Resource) connect() (gconn.Client, error) {
+	client, err := res.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := initializeTarget(client); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// initializeTarget creates the directories smutje requires on the target.
+func initializeTarget(client gconn.Client) error {
+	sess, err := client.NewSession("/usr/bin/env", "bash", "-c", `"mkdir -p /tmp/smutje && mkdir -p /var/lib/smutje"`)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Run()
+}
+
 func (res *Resource) initializeClient() (err error) {
 	var hypervisorType string
 	hypervisorType, res.isVirtual = res.Attributes["Hypervisor"]
@@ -131,6 +332,10 @@ func (res *Resource) initializeClient() (err error) {
 		res.username = "root"
 	}
 
+	if res.become, err = parseBecome(res.Attributes); err != nil {
+		return err
+	}
+
 	switch {
 	case res.isVirtual:
 		switch hypervisorType {
@@ -145,13 +350,29 @@ func (res *Resource) initializeClient() (err error) {
 
 		res.uuid, err = res.hypervisor.UUID(res.ID)
 		if err == nil && res.uuid != "" {
-			res.client, err = res.hypervisor.ConnectVRes(res.uuid)
+			res.client, err = res.dial()
 		}
-		return err
 	default:
-		res.client, err = gconn.NewSSHClient(res.address, res.username)
-		return err
+		res.client, err = res.dial()
 	}
+	return err
+}
+
+// dial establishes a connection to the resource, that must exist already.
+func (res *Resource) dial() (gconn.Client, error) {
+	var (
+		client gconn.Client
+		err    error
+	)
+	if res.isVirtual {
+		client, err = res.hypervisor.ConnectVRes(res.uuid)
+	} else {
+		client, err = gconn.NewSSHClient(res.address, res.username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBecomeClient(client, res.become), nil
 }
 
 func (res *Resource) setAddress() error {
@@ -186,12 +407,12 @@ func handleChild(parentID, path string, attrs Attributes, node *parser.AstNode)
 	case parser.AstAttributes:
 		newAttrs, err := newAttributes(node)
 		if err != nil {
-			return nil, err
+			return nil, node.Pos.Wrap(err)
 		}
 		if err := attrs.MergeInplace(newAttrs); err != nil {
-			return nil, err
+			return nil, node.Pos.Wrap(err)
 		}
-	case parser.AstPackage:
+	case parser.AstPackage, parser.AstHandler:
 		pkg, err := newPackage(parentID, path, attrs, node)
 		if err != nil {
 			return nil, err
@@ -206,7 +427,7 @@ func handleChild(parentID, path string, attrs Attributes, node *parser.AstNode)
 	case parser.AstText:
 	// ignore
 	default:
-		return nil, errors.Errorf("unexpected node seen: %s", node.Type)
+		return nil, node.Pos.Wrap(errors.Errorf("unexpected node seen: %s", node.Type))
 	}
 
 	return pkgs, nil
@@ -1,6 +1,9 @@
 package smutje
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestHandleChild(t *testing.T) {
 	res, err := ReadFile("testdata/test_handle_child_base.smd")
@@ -46,3 +49,34 @@ func TestHandleChild(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	if _, err := ReadFile("testdata/test_error_pos_attrs.smd"); err == nil {
+		t.Errorf("expected an error for an unknown attribute, got none")
+	} else if exp := "testdata/test_error_pos_attrs.smd:7: failed to render template"; !strings.HasPrefix(err.Error(), exp) {
+		t.Errorf("expected error to start with %q, got %q", exp, err)
+	}
+
+	res, err := ReadFile("testdata/test_error_pos_base.smd")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	tt := []struct {
+		pkg *smPackage
+		exp string
+	}{
+		{res.Packages[0], "testdata/test_error_pos_tmpl.smd:6: command :wirte_file unknown (included from testdata/test_error_pos_base.smd:5)"},
+		{res.Packages[1], "testdata/test_error_pos_base.smd:9: failed to render template"},
+	}
+
+	for i, tti := range tt {
+		err := tti.pkg.Prepare(nil, res.Attributes)
+		switch {
+		case err == nil:
+			t.Errorf("%d: expected an error, got none", i)
+		case !strings.HasPrefix(err.Error(), tti.exp):
+			t.Errorf("%d: expected error to start with %q, got %q", i, tti.exp, err)
+		}
+	}
+}
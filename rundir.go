@@ -0,0 +1,82 @@
+package smutje
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// Data of a single run (like the injected passwords) is stored in a private
+// directory on the target. It is referenced using the environment variable
+// set for all commands, so that the hashes of the scripts don't change from
+// run to run.
+const (
+	runDirEnv        = "SMUTJE_RUN_DIR"
+	cleanupRunDirCmd = `test -z "$SMUTJE_RUN_DIR" || rm -Rf "$SMUTJE_RUN_DIR"`
+)
+
+// newRunDir returns the name of a new private directory for the resource.
+func newRunDir(resID string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to create run id")
+	}
+	return fmt.Sprintf("/tmp/smutje/%s_%x", resID, buf), nil
+}
+
+// withRunDir sets the run directory for all commands of the client. The
+// returned function removes the directory from the target. It is also called
+// if the process is interrupted, as then the deferred calls are skipped.
+func withRunDir(l *log.Logger, client gconn.Client, runDir string) (gconn.Client, func()) {
+	runClient := newRunDirClient(client, runDir)
+
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			if err := cleanupRunDir(runClient); err != nil {
+				l.Printf("failed to remove %s: %s", runDir, err)
+			}
+		})
+	}
+
+	sigC := make(chan os.Signal, 1)
+	doneC := make(chan struct{})
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigC:
+			l.Printf("received %s, cleaning up", sig)
+			cleanup()
+			os.Exit(1)
+		case <-doneC:
+		}
+	}()
+
+	return runClient, func() {
+		signal.Stop(sigC)
+		close(doneC)
+		cleanup()
+	}
+}
+
+// newRunDirClient sets the run directory for all commands of the client.
+func newRunDirClient(client gconn.Client, runDir string) gconn.Client {
+	return newEnvClient(client, []string{runDirEnv + "=" + shellQuote(runDir)})
+}
+
+func cleanupRunDir(client gconn.Client) error {
+	sess, err := client.NewSession("/usr/bin/env", "bash", "-c", shellQuote(cleanupRunDirCmd))
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Run()
+}
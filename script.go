@@ -1,18 +1,48 @@
 package smutje
 
 import (
-	"log"
-
 	"github.com/gfrey/gconn"
 	"github.com/gfrey/smutje/parser"
 	"github.com/pkg/errors"
 )
 
-type smScript interface {
-	Prepare(attrs Attributes, prevHash string) (string, error)
-	Exec(l *log.Logger, client gconn.Client) error
-	Hash() string
-	MustExecute() bool
+// smScript is a step of a package. Bash scripts and the smutje commands share
+// the same contract.
+type smScript = Command
+
+// An interruptingScript is a script that might interrupt the connection to the
+// target (like a reboot). The package state is persisted right after such a
+// script was executed and the connection was re-established.
+type interruptingScript interface {
+	InterruptsConnection() bool
+}
+
+func interruptsConnection(s smScript) bool {
+	is, ok := s.(interruptingScript)
+	return ok && is.InterruptsConnection()
+}
+
+// A targetPreparer is a script that requires access to the target while being
+// prepared. The client is nil, if the target doesn't exist yet.
+type targetPreparer interface {
+	SetClient(client gconn.Client)
+}
+
+// An exportingScript is a script that provides attributes to the following
+// packages of the resource.
+type exportingScript interface {
+	Exports() Attributes
+}
+
+// An environmentScript is a script that applies the exported environment (see
+// Attributes.Environment) itself, so that it must not be applied again.
+type environmentScript interface {
+	HandlesEnvironment() bool
+}
+
+func handlesEnvironment(s smScript) bool {
+	es, ok := s.(environmentScript)
+	return ok && es.HandlesEnvironment()
 }
 
 func newScript(path string, n *parser.AstNode) (smScript, error) {
@@ -24,7 +54,10 @@ func newScript(path string, n *parser.AstNode) (smScript, error) {
 	case *parser.SmutjeScript:
 		return &smutjeScript{Path: path, ID: n.ID, rawCommand: s.Command}, nil
 	case *parser.BashScript:
-		return &bashScript{ID: n.ID, Script: s.Script}, nil
+		if s.Lang == parser.LangJSON {
+			return nil, errors.Errorf("json code blocks are only supported in blueprints")
+		}
+		return &bashScript{ID: n.ID, Script: s.Script, Lang: s.Lang}, nil
 	default:
 		return nil, errors.Errorf("expected a string value, got %T", n.Value)
 	}
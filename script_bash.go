@@ -5,16 +5,23 @@ import (
 	"fmt"
 	"io"
 	"log"
-
+	"path"
 	"strings"
 
 	"github.com/gfrey/gconn"
+	"github.com/gfrey/smutje/parser"
 	"github.com/pkg/errors"
 )
 
 type bashScript struct {
 	ID     string
 	Script string
+	Args   []string
+	// Lang is the language of the code block (empty for bash).
+	Lang string
+
+	script string
+	env    []string
 	hash   string
 }
 
@@ -26,27 +33,112 @@ func (s *bashScript) Hash() string {
 	return s.hash
 }
 
+// exportSecretsCmd exports the injected passwords as environment variables
+// `SMUTJE_PASSWORD_<name>` (characters not allowed in names are replaced with
+// an underscore). They are used by scripts of other interpreters than bash.
+const exportSecretsCmd = `if [ -f "$SMUTJE_RUN_DIR/passwords" ]; then ` +
+	`while IFS=$'\t' read -r k v; do export "SMUTJE_PASSWORD_${k//[^A-Za-z0-9_]/_}=$v"; done < "$SMUTJE_RUN_DIR/passwords"; fi`
+
+// langInterpreters are the interpreters used for code blocks of the given
+// language without a shebang line.
+var langInterpreters = map[string]string{
+	parser.LangPython: "python3",
+}
+
+// interpreter returns the interpreter set in the script's shebang line or
+// given by the code block's language. Bash scripts (with or without a shebang
+// line) result in an empty string.
+func (s *bashScript) interpreter() string {
+	if !strings.HasPrefix(s.Script, "#!") {
+		return langInterpreters[s.Lang]
+	}
+
+	line := strings.SplitN(s.Script, "\n", 2)[0]
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return ""
+	}
+
+	interp := path.Base(fields[0])
+	if interp == "env" {
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				interp = path.Base(f)
+				break
+			}
+		}
+	}
+
+	if interp == "bash" {
+		return ""
+	}
+	return interp
+}
+
 func (s *bashScript) Prepare(attrs Attributes, prevHash string) (string, error) {
-	script, err := renderString(s.ID, "set -e\n"+s.Script+"\n", attrs)
+	raw := "set -e\n" + s.Script + "\n"
+	if s.interpreter() != "" {
+		raw = s.Script + "\n"
+	}
+
+	// The script is persisted on the target, so secrets must only be
+	// referenced indirectly. That's only possible in bash, other interpreters
+	// read them from the environment (see exportSecretsCmd).
+	secretAttrs := attrs.indirectSecrets()
+	if s.interpreter() != "" {
+		secretAttrs = attrs.withoutSecrets()
+	}
+	script, err := renderString(s.ID, raw, secretAttrs)
+	switch {
+	case err != nil && s.interpreter() != "" && strings.Contains(raw, "PASSWORD_"):
+		return "", errors.Wrap(err, "secrets are only rendered into bash scripts, use the SMUTJE_PASSWORD_<name> environment variables")
+	case err != nil:
+		return "", err
+	}
+	s.script = script
+
+	s.env, err = attrs.Environment()
 	if err != nil {
 		return "", err
 	}
-	s.Script = script
-	s.hash = fmt.Sprintf("%x", md5.Sum([]byte(prevHash+s.Script)))
+
+	raw = prevHash + strings.Join(s.env, "\n") + s.script
+	if len(s.Args) > 0 {
+		raw += "\n" + strings.Join(s.Args, "\n")
+	}
+	// The language determines the interpreter. It's empty for bash, so that
+	// the hashes of existing scripts don't change.
+	if s.Lang != "" {
+		raw += "\nlang:" + s.Lang
+	}
+	s.hash = fmt.Sprintf("%x", md5.Sum([]byte(raw)))
 	return s.hash, nil
 }
 
 func (s *bashScript) Exec(l *log.Logger, client gconn.Client) error {
 	fname := fmt.Sprintf("/var/lib/smutje/%s.sh", s.hash)
 	cmd := fmt.Sprintf("cat - > %[1]s && bash -l %[1]s", fname)
+	logged := strings.TrimPrefix(s.script, "set -e\n")
+	if interp := s.interpreter(); interp != "" {
+		// scripts with a shebang line are executed directly, so that the
+		// interpreter is used.
+		l.Printf("using interpreter %s", interp)
+		cmd = fmt.Sprintf("%[2]s; cat - > %[1]s && chmod 0700 %[1]s && %[1]s", fname, exportSecretsCmd)
+		if !strings.HasPrefix(s.Script, "#!") {
+			cmd = fmt.Sprintf("%[3]s; cat - > %[1]s && %[2]s %[1]s", fname, interp, exportSecretsCmd)
+		}
+	}
+	for _, arg := range s.Args {
+		cmd += " " + shellQuote(arg)
+	}
 
-	sess, err := gconn.NewLoggedClient(l, client).NewSession("/usr/bin/env", "bash", "-c", fmt.Sprintf("%q", cmd))
+	sess, err := newLoggedClient(l, newEnvClient(client, s.env)).NewSession("/usr/bin/env", "bash", "-c", shellQuote(cmd))
 	if err != nil {
 		return err
 	}
 	defer sess.Close()
 
-	l.Printf("%s", strings.TrimSpace(s.Script[7:]))
+	l.Printf("%s", strings.TrimSpace(logged))
 
 	stdin, err := sess.StdinPipe()
 	if err != nil {
@@ -58,11 +150,11 @@ func (s *bashScript) Exec(l *log.Logger, client gconn.Client) error {
 		return err
 	}
 
-	switch n, err := io.WriteString(stdin, s.Script); {
+	switch n, err := io.WriteString(stdin, s.script); {
 	case err != nil:
 		return errors.Wrap(err, "failed to send script to target")
-	case n != len(s.Script):
-		return errors.Errorf("expected to send %d bytes, sent %d", len(s.Script), n)
+	case n != len(s.script):
+		return errors.Errorf("expected to send %d bytes, sent %d", len(s.script), n)
 	default:
 		stdin.Close()
 		return sess.Wait()
@@ -0,0 +1,116 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBashScriptInterpreter(t *testing.T) {
+	tt := []struct {
+		script    string
+		lang      string
+		interp    string
+		expPrefix string
+	}{
+		{"echo foo", "", "", "set -e\necho foo"},
+		{"#!/bin/bash\necho foo", "", "", "set -e\n#!/bin/bash"},
+		{"#!/usr/bin/env bash\necho foo", "", "", "set -e\n#!/usr/bin/env"},
+		{"#!/bin/sh\necho foo", "", "sh", "#!/bin/sh\necho foo"},
+		{"#!/usr/bin/env python3\nprint('foo')", "", "python3", "#!/usr/bin/env python3\n"},
+		{"#!/usr/bin/env -S perl -w\nprint 'foo'", "", "perl", "#!/usr/bin/env -S perl -w\n"},
+		{"# just a comment\necho foo", "", "", "set -e\n# just a comment"},
+		{"print('foo')", "python", "python3", "print('foo')"},
+		{"#!/usr/bin/env python2\nprint 'foo'", "python", "python2", "#!/usr/bin/env python2\n"},
+	}
+
+	for i, tti := range tt {
+		s := &bashScript{ID: "test", Script: tti.script, Lang: tti.lang}
+		if interp := s.interpreter(); interp != tti.interp {
+			t.Errorf("%d: expected interpreter %q, got %q", i, tti.interp, interp)
+		}
+
+		if _, err := s.Prepare(Attributes{}, ""); err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		if !strings.HasPrefix(s.script, tti.expPrefix) {
+			t.Errorf("%d: expected script to start with %q, got %q", i, tti.expPrefix, s.script)
+		}
+	}
+}
+
+func TestBashScriptArgsHash(t *testing.T) {
+	hashes := map[string]bool{}
+	for _, args := range [][]string{nil, {"a"}, {"a", "b"}, {"a b"}} {
+		s := &bashScript{ID: "test", Script: "echo $@", Args: args}
+		hash, err := s.Prepare(Attributes{}, "")
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+		if hashes[hash] {
+			t.Errorf("expected arguments %q to result in a different hash", args)
+		}
+		hashes[hash] = true
+	}
+}
+
+func TestBashScriptLangHash(t *testing.T) {
+	hashes := map[string]bool{}
+	for _, lang := range []string{"", "python"} {
+		s := &bashScript{ID: "test", Script: "print('foo')", Lang: lang}
+		hash, err := s.Prepare(Attributes{}, "")
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+		if hashes[hash] {
+			t.Errorf("expected language %q to result in a different hash", lang)
+		}
+		hashes[hash] = true
+	}
+
+	s := &bashScript{ID: "test", Script: "echo foo"}
+	if hash, err := s.Prepare(Attributes{}, ""); err != nil || hash != hA {
+		t.Errorf("expected the hash of bash scripts to be unchanged, got %s (%v)", hash, err)
+	}
+}
+
+func TestScriptCmdEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("echo foo\n"), 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	attrs := Attributes{"EnvExport": "1", "Foo": "bar"}
+
+	s := &smutjeScript{ID: "test", Path: dir, rawCommand: ":script run.sh"}
+	hash, err := s.Prepare(attrs, "")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	b := &bashScript{ID: "test", Script: "echo foo"}
+	expHash, err := b.Prepare(attrs, "")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if hash != expHash {
+		t.Errorf("expected hash %s, got %s", expHash, hash)
+	}
+
+	client := new(testClient)
+	client.failIdx = -1
+	if err := s.Exec(log.New(ioutil.Discard, "", 0), client); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if len(client.sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(client.sessions))
+	}
+	if cmd := client.sessions[0].command; strings.Count(cmd, "SMUTJE_FOO=") != 1 {
+		t.Errorf("expected the environment to be applied once, got %q", cmd)
+	}
+}
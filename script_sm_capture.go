@@ -0,0 +1,122 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// execCaptureCmd stores the output of a command run on the target in an
+// attribute. The command is run while preparing, so that the following steps
+// are hashed using the value. If the step is executed, the command is run again
+// as the preceding steps might have changed the value. If the command fails
+// while preparing, e.g. as a preceding step provides what it requires, the
+// value is determined on execution.
+type execCaptureCmd struct {
+	Attribute string
+	Command   string
+	Export    bool
+
+	client  gconn.Client
+	attrs   Attributes
+	value   string
+	pending bool
+
+	prevHash string
+	hash     string
+}
+
+func newCaptureCmd(raw string) (*execCaptureCmd, error) {
+	opts, raw := cutOptions(raw, "scope")
+
+	idx := strings.IndexAny(raw, " \t")
+	if idx == -1 || strings.TrimSpace(raw[idx:]) == "" {
+		return nil, errors.Errorf(`syntax error: capture usage ":capture [scope=package|resource] <attribute> <command>"`)
+	}
+
+	cmd := &execCaptureCmd{Attribute: raw[:idx], Command: strings.TrimSpace(raw[idx:])}
+	switch opts["scope"] {
+	case "", "package":
+	case "resource":
+		cmd.Export = true
+	default:
+		return nil, errors.Errorf("syntax error: invalid scope %q (expected package or resource)", opts["scope"])
+	}
+	return cmd, nil
+}
+
+func (a *execCaptureCmd) SetClient(client gconn.Client) {
+	a.client = client
+}
+
+func (a *execCaptureCmd) Exports() Attributes {
+	if !a.Export || a.pending {
+		return nil
+	}
+	return Attributes{a.Attribute: a.value}
+}
+
+func (a *execCaptureCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execCaptureCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	a.attrs, a.prevHash = attrs, prevHash
+
+	// If the target doesn't exist yet or the command fails, the value is
+	// determined on execution.
+	a.value, a.pending = "", true
+	if a.client != nil {
+		value, err := a.capture(log.New(ioutil.Discard, "", 0), a.client)
+		if err == nil {
+			a.value, a.pending = value, false
+		}
+	}
+
+	return a.updateHash()
+}
+
+func (a *execCaptureCmd) updateHash() (string, error) {
+	a.attrs[a.Attribute] = a.value
+
+	hash := md5.New()
+	if _, err := hash.Write([]byte(a.prevHash + "capture" + a.Attribute + "\n" + a.Command + "\n" + a.value)); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	if a.pending {
+		if _, err := hash.Write([]byte("pending")); err != nil {
+			return "", errors.Wrap(err, "failed to create command hash")
+		}
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execCaptureCmd) capture(l *log.Logger, client gconn.Client) (string, error) {
+	output, err := execRemoteScriptOutput(l, client, a.Command+"\n")
+	return strings.TrimSpace(output), err
+}
+
+func (a *execCaptureCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("capturing %s using %q", a.Attribute, a.Command)
+	value, err := a.capture(l, client)
+	if err != nil {
+		return err
+	}
+
+	if a.pending || value != a.value {
+		l.Printf("value of %s changed", a.Attribute)
+		a.value, a.pending = value, false
+		_, err = a.updateHash()
+	}
+	return err
+}
+
+func (*execCaptureCmd) MustExecute() bool {
+	return false
+}
@@ -0,0 +1,43 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestCapturePendingOnFailure(t *testing.T) {
+	cmd, err := newCaptureCmd("MachineID cat /etc/machine-id")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	// The first session, run while preparing, fails.
+	tc := &testClient{failIdx: 0, expCommand: "bash", cmdOutput: "abc\n"}
+	cmd.SetClient(tc)
+
+	attrs := Attributes{}
+	prepared, err := cmd.Prepare(attrs, "")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if !cmd.pending {
+		t.Errorf("expected the value to be pending")
+	}
+	if attrs["MachineID"] != "" {
+		t.Errorf("expected no value while pending, got %q", attrs["MachineID"])
+	}
+
+	if err := cmd.Exec(log.New(ioutil.Discard, "", 0), tc); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if cmd.pending {
+		t.Errorf("expected the value to be determined")
+	}
+	if attrs["MachineID"] != "abc" {
+		t.Errorf("expected value %q, got %q", "abc", attrs["MachineID"])
+	}
+	if cmd.Hash() == prepared {
+		t.Errorf("expected the hash to change once the value is determined")
+	}
+}
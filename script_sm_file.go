@@ -15,8 +15,7 @@ import (
 type execWriteFileCmd struct {
 	Source string
 	Target string
-	Owner  string
-	Umask  string
+	Meta   *fileMetadata
 
 	Render bool
 
@@ -25,24 +24,43 @@ type execWriteFileCmd struct {
 	size  int64
 }
 
+// sourceFile returns the path of the given local file. Relative names are
+// resolved against the path of the smutje file and must exist.
+func sourceFile(path, filename string) (string, error) {
+	if filename == "" {
+		return "", errors.Errorf("syntax error: empty file name")
+	}
+
+	if filepath.IsAbs(filename) {
+		return filename, nil
+	}
+
+	filename = filepath.Join(path, filename)
+	if _, err := os.Stat(filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
 func newExecWriteFileCmd(path string, args []string) (*execWriteFileCmd, error) {
+	meta, args := parseFileMetadata(args)
+
 	if len(args) < 2 || len(args) == 3 || len(args) > 4 {
-		return nil, errors.Errorf(`syntax error: write file/template usage ":write_file <source> <target> [<user> <umask>]?"`)
+		return nil, errors.Errorf(`syntax error: write file/template usage ":write_file <source> <target> [<user> <umask>]? [owner=<user>] [group=<group>] [mode=<mode>]"`)
 	}
 
-	filename := args[0]
-	if filename[0] != '/' {
-		filename = filepath.Join(path, args[0])
-		if _, err := os.Stat(filename); err != nil {
-			return nil, err
-		}
+	filename, err := sourceFile(path, args[0])
+	if err != nil {
+		return nil, err
 	}
 
-	cmd := &execWriteFileCmd{Source: filename, Target: args[1], Render: false}
+	cmd := &execWriteFileCmd{Source: filename, Target: args[1], Meta: meta, Render: false}
 
 	if len(args) > 2 {
-		cmd.Owner = args[2]
-		cmd.Umask = args[3]
+		if meta.Owner != "" || meta.Mode != "" {
+			return nil, errors.Errorf("syntax error: owner and mode given both positional and as option")
+		}
+		meta.Owner, meta.Mode = args[2], args[3]
 	}
 
 	return cmd, nil
@@ -71,7 +89,7 @@ func (a *execWriteFileCmd) Prepare(attrs Attributes, prevHash string) (string, e
 	defer r.Close()
 
 	hash := md5.New()
-	if _, err := hash.Write([]byte(prevHash + a.Target + a.Owner + a.Umask)); err != nil {
+	if _, err := hash.Write([]byte(prevHash + a.Target + a.Meta.Hash())); err != nil {
 		return "", err
 	}
 	size, err := io.Copy(hash, r)
@@ -99,13 +117,8 @@ func (a *execWriteFileCmd) Exec(l *log.Logger, clients gconn.Client) error {
 	defer r.Close()
 
 	l.Printf("writing file %q", a.Target)
-	rawCmd := "{ dir=$(dirname %[1]s); test -d ${dir} || mkdir -p ${dir}; } && cat - > %[1]s"
-	// TODO is possible to set only one of the both?
-	if a.Owner != "" && a.Umask != "" {
-		rawCmd += " && chown " + a.Owner + " %[1]s && chmod " + a.Umask + " %[1]s"
-	}
-	cmd := fmt.Sprintf("'"+rawCmd+"'", a.Target)
-	sess, err := gconn.NewLoggedClient(l, clients).NewSession("/usr/bin/env", "sh", "-c", cmd)
+	cmd := parentDirCmd(a.Target) + " && cat - > " + shellQuote(a.Target) + a.Meta.Commands(a.Target, false)
+	sess, err := newLoggedClient(l, clients).NewSession("/usr/bin/env", "sh", "-c", shellQuote(cmd))
 	if err != nil {
 		return err
 	}
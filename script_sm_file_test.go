@@ -0,0 +1,74 @@
+package smutje
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runRecorded executes the command recorded by the test client locally, like
+// the shell on the target would do.
+func runRecorded(t *testing.T, tc *testClient, stdin string) {
+	t.Helper()
+
+	if len(tc.sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(tc.sessions))
+	}
+	cmd := exec.Command("sh", "-c", tc.sessions[0].command)
+	if stdin != "" {
+		cmd.Stdin = bytes.NewBufferString(stdin)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("didn't expect an error, got: %s (%s)", err, out)
+	}
+}
+
+func TestFileCommandsQuoting(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+	dir := filepath.Join(t.TempDir(), "it's a dir")
+	meta, _ := parseFileMetadata([]string{"mode=0700"})
+
+	tc := &testClient{failIdx: -1}
+	mkdir := &execMkdirCmd{Target: filepath.Join(dir, "sub dir"), Meta: meta}
+	if err := mkdir.Exec(l, tc); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	runRecorded(t, tc, "")
+	if fi, err := os.Stat(mkdir.Target); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	} else if fi.Mode().Perm() != 0700 {
+		t.Errorf("expected mode 0700, got %o", fi.Mode().Perm())
+	}
+
+	tc = &testClient{failIdx: -1}
+	source := filepath.Join(t.TempDir(), "source")
+	if err := ioutil.WriteFile(source, []byte("content"), 0644); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	file := &execWriteFileCmd{Source: source, Target: filepath.Join(dir, "new dir", "it's a file"), Meta: new(fileMetadata)}
+	if err := file.Exec(l, tc); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	runRecorded(t, tc, tc.sessions[0].Stdin.String())
+	if got, err := ioutil.ReadFile(file.Target); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	} else if string(got) != "content" {
+		t.Errorf("expected content %q, got %q", "content", got)
+	}
+
+	tc = &testClient{failIdx: -1}
+	link := &execSymlinkCmd{Source: file.Target, Target: filepath.Join(dir, "link dir", "it's a link"), Meta: new(fileMetadata)}
+	if err := link.Exec(l, tc); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	runRecorded(t, tc, "")
+	if got, err := os.Readlink(link.Target); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	} else if got != file.Target {
+		t.Errorf("expected link to %q, got %q", file.Target, got)
+	}
+}
@@ -18,26 +18,39 @@ type execJenkinsArtifactCmd struct {
 	Job      string
 	Artifact string
 	Target   string
-	Owner    string
-	Umask    string
+	Meta     *fileMetadata
 
 	hash string
 	url  string
 }
 
 func newJenkinsArtifactCmd(args []string) (*execJenkinsArtifactCmd, error) {
+	meta, args := parseFileMetadata(args)
+
 	if len(args) < 4 || len(args) > 6 {
-		return nil, errors.Errorf(`syntax error: jenkins artifact usage ":jenkins_artifact <host> <job> <artifact> <target> [<user> <umask>]?"`)
+		return nil, errors.Errorf(`syntax error: jenkins artifact usage ":jenkins_artifact <host> <job> <artifact> <target> [<user> <umask>]? [owner=<user>] [group=<group>] [mode=<mode>]"`)
 	}
 
 	cmd := new(execJenkinsArtifactCmd)
 	cmd.Host, cmd.Job, cmd.Artifact, cmd.Target = args[0], args[1], args[2], args[3]
-	cmd.Owner, cmd.Umask = "root", "0644"
+	cmd.Meta = meta
 	if len(args) > 4 {
-		cmd.Owner = args[4]
+		if meta.Owner != "" {
+			return nil, errors.Errorf("syntax error: owner given both positional and as option")
+		}
+		meta.Owner = args[4]
 	}
 	if len(args) == 6 {
-		cmd.Umask = args[5]
+		if meta.Mode != "" {
+			return nil, errors.Errorf("syntax error: mode given both positional and as option")
+		}
+		meta.Mode = args[5]
+	}
+	if meta.Owner == "" && meta.Group == "" {
+		meta.Owner = "root"
+	}
+	if meta.Mode == "" {
+		meta.Mode = "0644"
 	}
 
 	return cmd, nil
@@ -67,7 +80,7 @@ func (a *execJenkinsArtifactCmd) Prepare(attrs Attributes, prevHash string) (str
 	fingerprint := strings.SplitN(parts[1], " ", 2)[0]
 
 	hash := md5.New()
-	if _, err := hash.Write([]byte(prevHash + a.Host + a.Job + a.Artifact + fingerprint)); err != nil {
+	if _, err := hash.Write([]byte(prevHash + a.Host + a.Job + a.Artifact + fingerprint + "\n" + a.Target + a.Meta.Hash())); err != nil {
 		return "", errors.Wrap(err, "failed to create command hash")
 	}
 	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
@@ -77,14 +90,8 @@ func (a *execJenkinsArtifactCmd) Prepare(attrs Attributes, prevHash string) (str
 
 func (a *execJenkinsArtifactCmd) Exec(l *log.Logger, client gconn.Client) error {
 	l.Printf("downloading file %q from %q", a.Target, a.url)
-	rawCmd := "{ dir=$(dirname %[1]s); test -d ${dir} || mkdir -p ${dir}; } && curl -sSL %[2]s -o %[1]s"
-	// TODO is possible to set only one of the both?
-	if a.Owner != "" && a.Umask != "" {
-		rawCmd += " && chown " + a.Owner + " %[1]s && chmod " + a.Umask + " %[1]s"
-	}
-
-	cmd := fmt.Sprintf("'"+rawCmd+"'", a.Target, a.url)
-	sess, err := gconn.NewLoggedClient(l, client).NewSession("/usr/bin/env", "bash", "-c", cmd)
+	cmd := parentDirCmd(a.Target) + " && curl -sSL " + shellQuote(a.url) + " -o " + shellQuote(a.Target) + a.Meta.Commands(a.Target, false)
+	sess, err := newLoggedClient(l, client).NewSession("/usr/bin/env", "bash", "-c", shellQuote(cmd))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,231 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// The edit is done on a temporary copy of the target (so that ownership and
+// permissions are retained), that is moved over the target only if the content
+// changed.
+const editFileScript = `set -e
+tgt=%[1]s
+%[2]s
+if [ ! -e "$tgt" ]; then
+	if [ %[3]s = absent ]; then
+		echo "unchanged"
+		exit 0
+	fi
+	dir=$(dirname "$tgt"); test -d "$dir" || mkdir -p "$dir"
+	touch "$tgt"
+fi
+tmp=$(mktemp "$tgt.smutje.XXXXXX")
+trap 'rm -f "$tmp"' EXIT
+cp -p "$tgt" "$tmp"
+awk %[4]s "$tgt" > "$tmp"
+if cmp -s "$tgt" "$tmp"; then
+	echo "unchanged"
+else
+	mv -f "$tmp" "$tgt"
+	echo "changed"
+fi
+`
+
+const (
+	awkLineHeader = `BEGIN { line = ENVIRON["SMUTJE_LINE"]; re = ENVIRON["SMUTJE_RE"]; done = 0 }
+`
+	awkLinePresent = awkLineHeader + `!done && ($0 == line || (re != "" && $0 ~ re)) { print line; done = 1; next }
+{ print }
+END { if (!done) print line }
+`
+	awkLineAbsent = awkLineHeader + `(re == "" && $0 == line) || (re != "" && $0 ~ re) { next }
+{ print }
+`
+
+	awkBlockHeader = `BEGIN { b = ENVIRON["SMUTJE_BEGIN"]; e = ENVIRON["SMUTJE_END"]; blk = ENVIRON["SMUTJE_BLOCK"]; inb = 0; done = 0 }
+`
+	awkBlockPresent = awkBlockHeader + `$0 == b { inb = 1; if (!done) { print b; printf "%s", blk; print e; done = 1 }; next }
+inb && $0 == e { inb = 0; next }
+inb { next }
+{ print }
+END { if (!done) { print b; printf "%s", blk; print e } }
+`
+	awkBlockAbsent = awkBlockHeader + `$0 == b { inb = 1; next }
+inb && $0 == e { inb = 0; next }
+inb { next }
+{ print }
+`
+)
+
+func parseEditState(opts map[string]string) (string, error) {
+	switch state := opts["state"]; state {
+	case "":
+		return "present", nil
+	case "present", "absent":
+		return state, nil
+	default:
+		return "", errors.Errorf("syntax error: invalid state %q (expected present or absent)", state)
+	}
+}
+
+type execLineInFileCmd struct {
+	Target string
+	Line   string
+	Regexp string
+	State  string
+
+	hash string
+}
+
+func newLineInFileCmd(args []string) (*execLineInFileCmd, error) {
+	opts, args := parseOptions(args, "regexp", "state")
+
+	state, err := parseEditState(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := &execLineInFileCmd{Regexp: opts["regexp"], State: state}
+	switch {
+	case len(args) == 2:
+		cmd.Target, cmd.Line = args[0], args[1]
+	case len(args) == 1 && state == "absent" && cmd.Regexp != "":
+		cmd.Target = args[0]
+	default:
+		return nil, errors.Errorf(`syntax error: line in file usage ":line_in_file <target> <line> [regexp=<regexp>] [state=present|absent]"`)
+	}
+
+	return cmd, nil
+}
+
+func (a *execLineInFileCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execLineInFileCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "line_in_file" + a.Target + "\n" + a.Line + "\n" + a.Regexp + "\n" + a.State)); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execLineInFileCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("ensuring line %q is %s in %q", a.Line, a.State, a.Target)
+	return execRemoteScript(l, client, a.script())
+}
+
+func (a *execLineInFileCmd) script() string {
+	prog := awkLinePresent
+	if a.State == "absent" {
+		prog = awkLineAbsent
+	}
+
+	env := fmt.Sprintf("export SMUTJE_LINE=%s SMUTJE_RE=%s", shellQuote(a.Line), shellQuote(a.Regexp))
+	return fmt.Sprintf(editFileScript, shellQuote(a.Target), env, a.State, shellQuote(prog))
+}
+
+func (*execLineInFileCmd) MustExecute() bool {
+	return false
+}
+
+type execBlockInFileCmd struct {
+	Source string
+	Target string
+	Marker string
+	State  string
+
+	block string
+	hash  string
+}
+
+func newBlockInFileCmd(path string, args []string) (*execBlockInFileCmd, error) {
+	opts, args := parseOptions(args, "marker", "state")
+
+	state, err := parseEditState(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := &execBlockInFileCmd{Marker: opts["marker"], State: state}
+	switch {
+	case len(args) == 2:
+		cmd.Target = args[0]
+
+		filename, err := sourceFile(path, args[1])
+		if err != nil {
+			return nil, err
+		}
+		cmd.Source = filename
+
+		if cmd.Marker == "" {
+			cmd.Marker = args[1]
+		}
+	case len(args) == 1 && state == "absent" && cmd.Marker != "":
+		cmd.Target = args[0]
+	default:
+		return nil, errors.Errorf(`syntax error: block in file usage ":block_in_file <target> <source> [marker=<marker>] [state=present|absent]"`)
+	}
+
+	return cmd, nil
+}
+
+func (a *execBlockInFileCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execBlockInFileCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	if a.Source != "" && a.State == "present" {
+		r, err := renderFile(a.Source, attrs)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+
+		buf := new(strings.Builder)
+		if _, err := io.Copy(buf, r); err != nil {
+			return "", errors.Wrap(err, "failed to read block")
+		}
+
+		a.block = buf.String()
+		if a.block != "" && !strings.HasSuffix(a.block, "\n") {
+			a.block += "\n"
+		}
+	}
+
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "block_in_file" + a.Target + "\n" + a.Marker + "\n" + a.State + "\n" + a.block)); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execBlockInFileCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("ensuring block %q is %s in %q", a.Marker, a.State, a.Target)
+	return execRemoteScript(l, client, a.script())
+}
+
+func (a *execBlockInFileCmd) script() string {
+	prog := awkBlockPresent
+	if a.State == "absent" {
+		prog = awkBlockAbsent
+	}
+
+	env := fmt.Sprintf("export SMUTJE_BEGIN=%s SMUTJE_END=%s SMUTJE_BLOCK=%s",
+		shellQuote("# BEGIN SMUTJE MANAGED BLOCK "+a.Marker),
+		shellQuote("# END SMUTJE MANAGED BLOCK "+a.Marker),
+		shellQuote(a.block))
+	return fmt.Sprintf(editFileScript, shellQuote(a.Target), env, a.State, shellQuote(prog))
+}
+
+func (*execBlockInFileCmd) MustExecute() bool {
+	return false
+}
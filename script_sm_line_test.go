@@ -0,0 +1,113 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runEditScript runs the edit script locally on a file with the given content
+// and returns the resulting content and the script's output.
+func runEditScript(t *testing.T, content *string, script func(target string) string) (string, string) {
+	t.Helper()
+
+	target := filepath.Join(t.TempDir(), "dir", "file")
+	if content != nil {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+		if err := ioutil.WriteFile(target, []byte(*content), 0644); err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+	}
+
+	out, err := exec.Command("bash", "-c", script(target)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s (%s)", err, out)
+	}
+
+	got, err := ioutil.ReadFile(target)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	return string(got), strings.TrimSpace(string(out))
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestLineInFile(t *testing.T) {
+	tt := []struct {
+		args      []string
+		content   *string
+		exp       string
+		expOutput string
+	}{
+		{[]string{"a b"}, strPtr("x\n"), "x\na b\n", "changed"},
+		{[]string{"a b"}, strPtr("x\na b\ny\n"), "x\na b\ny\n", "unchanged"},
+		{[]string{"a b"}, nil, "a b\n", "changed"},
+		{[]string{"port=22", "regexp=^#?port="}, strPtr("x\n#port=2222\ny\nport=1\n"), "x\nport=22\ny\nport=1\n", "changed"},
+		{[]string{"port=22", "regexp=^#?port="}, strPtr("x\n"), "x\nport=22\n", "changed"},
+		{[]string{"a b", "state=absent"}, strPtr("a b\nx\na b\n"), "x\n", "changed"},
+		{[]string{"regexp=^#", "state=absent"}, strPtr("# a\nx\n# b\n"), "x\n", "changed"},
+		{[]string{"a b", "state=absent"}, nil, "", "unchanged"},
+		{[]string{`it's "quoted" $HOME`}, strPtr(""), "it's \"quoted\" $HOME\n", "changed"},
+	}
+
+	for i, tti := range tt {
+		cmd, err := newLineInFileCmd(append([]string{"target"}, tti.args...))
+		if err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		got, out := runEditScript(t, tti.content, func(target string) string {
+			cmd.Target = target
+			return cmd.script()
+		})
+		if got != tti.exp {
+			t.Errorf("%d: expected content %q, got %q", i, tti.exp, got)
+		}
+		if out != tti.expOutput {
+			t.Errorf("%d: expected output %q, got %q", i, tti.expOutput, out)
+		}
+	}
+}
+
+func TestBlockInFile(t *testing.T) {
+	const (
+		begin = "# BEGIN SMUTJE MANAGED BLOCK m\n"
+		end   = "# END SMUTJE MANAGED BLOCK m\n"
+	)
+
+	tt := []struct {
+		block     string
+		state     string
+		content   *string
+		exp       string
+		expOutput string
+	}{
+		{"a\nb\n", "present", strPtr("x\n"), "x\n" + begin + "a\nb\n" + end, "changed"},
+		{"a\nb\n", "present", strPtr("x\n" + begin + "old\n" + end + "y\n"), "x\n" + begin + "a\nb\n" + end + "y\n", "changed"},
+		{"a\nb\n", "present", strPtr(begin + "a\nb\n" + end), begin + "a\nb\n" + end, "unchanged"},
+		{"a\n", "present", nil, begin + "a\n" + end, "changed"},
+		{"", "absent", strPtr("x\n" + begin + "old\n" + end + "y\n"), "x\ny\n", "changed"},
+		{"", "absent", strPtr("x\n"), "x\n", "unchanged"},
+	}
+
+	for i, tti := range tt {
+		cmd := &execBlockInFileCmd{Marker: "m", State: tti.state, block: tti.block}
+		got, out := runEditScript(t, tti.content, func(target string) string {
+			cmd.Target = target
+			return cmd.script()
+		})
+		if got != tti.exp {
+			t.Errorf("%d: expected content %q, got %q", i, tti.exp, got)
+		}
+		if out != tti.expOutput {
+			t.Errorf("%d: expected output %q, got %q", i, tti.expOutput, out)
+		}
+	}
+}
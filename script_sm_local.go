@@ -0,0 +1,222 @@
+package smutje
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+const localCaptureFoundMarker = "SMUTJE_LOCAL_CAPTURE_FOUND"
+
+// execLocalCmd runs a command on the machine running smutje. If the output
+// is captured into an attribute, it is stored on the target, so that the
+// following steps are hashed using the same value as long as the step is
+// cached. The command itself is only run if the step is executed.
+type execLocalCmd struct {
+	Path    string
+	Command string
+	Capture string
+
+	client  gconn.Client
+	attrs   Attributes
+	env     []string
+	output  string
+	pending bool
+
+	prevHash string
+	hash     string
+}
+
+func newLocalCmd(path, raw string) (*execLocalCmd, error) {
+	opts, command := cutOptions(raw, "capture")
+	if command == "" {
+		return nil, errors.Errorf(`syntax error: local usage ":local [capture=<attribute>] <command>"`)
+	}
+
+	return &execLocalCmd{Path: path, Command: command, Capture: opts["capture"]}, nil
+}
+
+func (a *execLocalCmd) SetClient(client gconn.Client) {
+	a.client = client
+}
+
+func (a *execLocalCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execLocalCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	a.attrs, a.prevHash = attrs, prevHash
+
+	a.env = os.Environ()
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		a.env = append(a.env, k+"="+attrs[k])
+	}
+
+	if a.Capture != "" {
+		// Without a stored value the step must be executed to determine it.
+		a.output, a.pending = "", true
+		if a.client != nil {
+			buf := bytes.NewBuffer(nil)
+			output, found, err := a.readCaptured(log.New(buf, "", 0), a.client)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to read captured value of %s: %s", a.Capture, strings.TrimSpace(buf.String()))
+			}
+			if found {
+				a.output, a.pending = output, false
+			}
+		}
+		attrs[a.Capture] = a.output
+	}
+
+	return a.updateHash()
+}
+
+func (a *execLocalCmd) updateHash() (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(a.prevHash + "local" + a.Command + "\n" + a.Capture + "\n" + a.output)); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	if a.pending {
+		if _, err := hash.Write([]byte("pending")); err != nil {
+			return "", errors.Wrap(err, "failed to create command hash")
+		}
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+// captureFile returns the file on the target the captured value is stored in.
+// It is determined by the command and the preceding steps.
+func (a *execLocalCmd) captureFile() string {
+	key := md5.Sum([]byte(a.prevHash + "local" + a.Command + "\n" + a.Capture))
+	return fmt.Sprintf("/var/lib/smutje/%x.local", key)
+}
+
+func (a *execLocalCmd) readCaptured(l *log.Logger, client gconn.Client) (string, bool, error) {
+	script := fmt.Sprintf("f=%s\nif [ -e \"$f\" ]; then echo %s; cat \"$f\"; fi\n", shellQuote(a.captureFile()), localCaptureFoundMarker)
+	output, err := execRemoteScriptOutput(l, client, script)
+	if err != nil {
+		return "", false, err
+	}
+	if !strings.HasPrefix(output, localCaptureFoundMarker+"\n") {
+		return "", false, nil
+	}
+	return strings.TrimPrefix(output, localCaptureFoundMarker+"\n"), true, nil
+}
+
+func (a *execLocalCmd) storeCaptured(client gconn.Client) error {
+	cmd := fmt.Sprintf("umask 077 && cat - > %s", shellQuote(a.captureFile()))
+	sess, err := client.NewSession("/usr/bin/env", "bash", "-c", shellQuote(cmd))
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to receive stdin pipe")
+	}
+
+	if err := sess.Start(); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(stdin, a.output); err != nil {
+		stdin.Close()
+		return errors.Wrap(err, "failed to send captured value to target")
+	}
+	stdin.Close()
+	return sess.Wait()
+}
+
+func (a *execLocalCmd) Exec(l *log.Logger, client gconn.Client) error {
+	deadline, _ := clientDeadline(client)
+
+	if a.Capture != "" {
+		l.Printf("capturing output of local command %q into %s", a.Command, a.Capture)
+
+		stdout := bytes.NewBuffer(nil)
+		stderrR, stderrW := io.Pipe()
+		errC := make(chan error, 1)
+		go logStream(l, stderrTag, stderrR, errC)
+
+		err := a.run(deadline, stdout, stderrW)
+		stderrW.Close()
+		if e := <-errC; err == nil {
+			err = e
+		}
+		if err != nil {
+			return err
+		}
+
+		a.output, a.pending = strings.TrimSpace(stdout.String()), false
+		if err := a.storeCaptured(client); err != nil {
+			return errors.Wrap(err, "failed to store captured value")
+		}
+		a.attrs[a.Capture] = a.output
+		_, err = a.updateHash()
+		return err
+	}
+
+	l.Printf("running local command %q", a.Command)
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+
+	errC := make(chan error, 2)
+	go logStream(l, stdoutTag, stdoutR, errC)
+	go logStream(l, stderrTag, stderrR, errC)
+
+	err := a.run(deadline, stdoutW, stderrW)
+	stdoutW.Close()
+	stderrW.Close()
+	for i := 0; i < 2; i++ {
+		if e := <-errC; err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// run runs the command. It is killed if the given deadline (if set) is
+// exceeded.
+func (a *execLocalCmd) run(deadline time.Time, stdout, stderr io.Writer) error {
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "/usr/bin/env", "bash", "-c", a.Command)
+	cmd.Dir = a.Path
+	cmd.Env = a.env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// children of the killed shell might keep the output open
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errTimeout
+	}
+	return errors.Wrap(err, "failed to run local command")
+}
+
+func (*execLocalCmd) MustExecute() bool {
+	return false
+}
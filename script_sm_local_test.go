@@ -0,0 +1,110 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestLocalCapture(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+
+	newCmd := func() *execLocalCmd {
+		cmd, err := newLocalCmd(dir, "capture=Key touch "+marker+" && echo generated")
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+		return cmd
+	}
+
+	// The command isn't run while preparing, even if nothing is stored yet.
+	cmd := newCmd()
+	attrs := Attributes{}
+	pendingHash, err := cmd.Prepare(attrs, "prev")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the command not to be run while preparing")
+	}
+	if !cmd.pending || attrs["Key"] != "" {
+		t.Errorf("expected the value to be pending, got %q", attrs["Key"])
+	}
+
+	// Executing runs the command and stores the value on the target.
+	client := new(testClient)
+	client.failIdx = -1
+	if err := cmd.Exec(l, client); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the command to be run on execution")
+	}
+	if attrs["Key"] != "generated" || cmd.Hash() == pendingHash {
+		t.Errorf("expected value and hash to be updated, got %q", attrs["Key"])
+	}
+	if len(client.sessions) != 1 || client.sessions[0].Stdin.String() != "generated" {
+		t.Errorf("expected the value to be stored on the target")
+	}
+	execHash := cmd.Hash()
+
+	// A stored value is used while preparing, so the step stays cached.
+	if err := os.Remove(marker); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	client = new(testClient)
+	client.failIdx = -1
+	client.expCommand = "bash"
+	client.cmdOutput = localCaptureFoundMarker + "\ngenerated"
+
+	cmd = newCmd()
+	cmd.SetClient(client)
+	attrs = Attributes{}
+	hash, err := cmd.Prepare(attrs, "prev")
+	switch {
+	case err != nil:
+		t.Fatalf("didn't expect an error, got: %s", err)
+	case hash != execHash:
+		t.Errorf("expected hash %q of the executed step, got %q", execHash, hash)
+	case attrs["Key"] != "generated":
+		t.Errorf("expected the stored value to be used, got %q", attrs["Key"])
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the command not to be run while preparing")
+	}
+}
+
+func TestLocalTimeout(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+
+	cmd, err := newLocalCmd(t.TempDir(), "sleep 5; true")
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if _, err := cmd.Prepare(Attributes{}, ""); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	start := time.Now()
+	err = cmd.Exec(l, newDeadlineClient(new(testClient), time.Now().Add(100*time.Millisecond)))
+	if errors.Cause(err) != errTimeout {
+		t.Errorf("expected a timeout error, got: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("expected the command to be killed at the deadline, took %s", d)
+	}
+}
+
+func TestLocalCaptureUsage(t *testing.T) {
+	if _, err := newLocalCmd("", "capture=Key"); err == nil || !strings.Contains(err.Error(), "local usage") {
+		t.Errorf("expected a usage error, got: %v", err)
+	}
+}
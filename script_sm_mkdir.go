@@ -0,0 +1,56 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+type execMkdirCmd struct {
+	Target string
+	Meta   *fileMetadata
+
+	hash string
+}
+
+func newMkdirCmd(args []string) (*execMkdirCmd, error) {
+	meta, args := parseFileMetadata(args)
+
+	if len(args) != 1 {
+		return nil, errors.Errorf(`syntax error: mkdir usage ":mkdir <target> [owner=<user>] [group=<group>] [mode=<mode>]"`)
+	}
+
+	return &execMkdirCmd{Target: args[0], Meta: meta}, nil
+}
+
+func (a *execMkdirCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execMkdirCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "mkdir" + a.Target + a.Meta.Hash())); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execMkdirCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("creating directory %q", a.Target)
+	cmd := "mkdir -p " + shellQuote(a.Target) + a.Meta.Commands(a.Target, false)
+	sess, err := newLoggedClient(l, client).NewSession("/usr/bin/env", "sh", "-c", shellQuote(cmd))
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Run()
+}
+
+func (*execMkdirCmd) MustExecute() bool {
+	return false
+}
@@ -0,0 +1,115 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// The package manager is detected on the target. Only the packages missing
+// (or still installed, if they should be absent) are handed to it.
+const packagesScript = `set -e
+state=%[1]s
+set -- %[2]s
+
+if command -v apt-get >/dev/null 2>&1; then
+	installed() { dpkg-query -W -f='${Status}' "$1" 2>/dev/null | grep -q "ok installed"; }
+	install() { apt-get update -q && DEBIAN_FRONTEND=noninteractive apt-get install -y -q "$@"; }
+	remove() { DEBIAN_FRONTEND=noninteractive apt-get remove -y -q "$@"; }
+elif command -v dnf >/dev/null 2>&1; then
+	installed() { rpm -q "$1" >/dev/null 2>&1; }
+	install() { dnf install -y "$@"; }
+	remove() { dnf remove -y "$@"; }
+elif command -v yum >/dev/null 2>&1; then
+	installed() { rpm -q "$1" >/dev/null 2>&1; }
+	install() { yum install -y "$@"; }
+	remove() { yum remove -y "$@"; }
+elif command -v apk >/dev/null 2>&1; then
+	installed() { apk info -e "$1" >/dev/null 2>&1; }
+	install() { apk add "$@"; }
+	remove() { apk del "$@"; }
+elif command -v pkgin >/dev/null 2>&1; then
+	installed() { pkg_info -e "$1" >/dev/null 2>&1; }
+	install() { pkgin -y install "$@"; }
+	remove() { pkgin -y remove "$@"; }
+else
+	echo "no supported package manager found" >&2
+	exit 1
+fi
+
+todo=""
+for pkg in "$@"; do
+	if installed "$pkg"; then
+		if [ "$state" = absent ]; then todo="$todo $pkg"; fi
+	else
+		if [ "$state" = present ]; then todo="$todo $pkg"; fi
+	fi
+done
+
+if [ -z "$todo" ]; then
+	echo "unchanged"
+	exit 0
+fi
+
+if [ "$state" = present ]; then
+	install $todo
+else
+	remove $todo
+fi
+echo "changed:$todo"
+`
+
+type execPackagesCmd struct {
+	Packages []string
+	State    string
+
+	hash string
+}
+
+func newPackagesCmd(args []string) (*execPackagesCmd, error) {
+	opts, args := parseOptions(args, "state")
+
+	state, err := parseEditState(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, errors.Errorf(`syntax error: packages usage ":packages <package>... [state=present|absent]"`)
+	}
+
+	return &execPackagesCmd{Packages: args, State: state}, nil
+}
+
+func (a *execPackagesCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execPackagesCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "packages" + a.State + "\n" + strings.Join(a.Packages, "\n"))); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execPackagesCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("ensuring packages %s are %s", strings.Join(a.Packages, " "), a.State)
+
+	quoted := make([]string, len(a.Packages))
+	for i, pkg := range a.Packages {
+		quoted[i] = shellQuote(pkg)
+	}
+
+	script := fmt.Sprintf(packagesScript, a.State, strings.Join(quoted, " "))
+	return execRemoteScript(l, client, script)
+}
+
+func (*execPackagesCmd) MustExecute() bool {
+	return false
+}
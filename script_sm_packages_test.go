@@ -0,0 +1,62 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackagesCmd(t *testing.T) {
+	tt := []struct {
+		args     []string
+		expPkgs  []string
+		expState string
+		err      string
+	}{
+		{[]string{"nginx"}, []string{"nginx"}, "present", ""},
+		{[]string{"nginx", "curl"}, []string{"nginx", "curl"}, "present", ""},
+		{[]string{"state=absent", "telnet"}, []string{"telnet"}, "absent", ""},
+		{[]string{"telnet", "state=present"}, []string{"telnet"}, "present", ""},
+		{[]string{}, nil, "", "packages usage"},
+		{[]string{"state=absent"}, nil, "", "packages usage"},
+		{[]string{"nginx", "state=latest"}, nil, "", "invalid state"},
+	}
+
+	for i, tti := range tt {
+		cmd, err := newPackagesCmd(tti.args)
+		switch {
+		case tti.err == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.err != "" && err == nil:
+			t.Errorf("%d: expected error %q, got none", i, tti.err)
+		case tti.err != "" && !strings.Contains(err.Error(), tti.err):
+			t.Errorf("%d: expected error %q, got %q", i, tti.err, err)
+		case tti.err != "":
+			// expected error received
+		case strings.Join(cmd.Packages, " ") != strings.Join(tti.expPkgs, " "):
+			t.Errorf("%d: expected packages %q, got %q", i, tti.expPkgs, cmd.Packages)
+		case cmd.State != tti.expState:
+			t.Errorf("%d: expected state %q, got %q", i, tti.expState, cmd.State)
+		}
+	}
+}
+
+func TestPackagesCmdHash(t *testing.T) {
+	hash := func(args ...string) string {
+		cmd, err := newPackagesCmd(args)
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+		h, err := cmd.Prepare(Attributes{}, "prev")
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+		return h
+	}
+
+	if hash("nginx") == hash("nginx", "state=absent") {
+		t.Errorf("expected the state to change the hash")
+	}
+	if hash("nginx", "curl") == hash("nginx curl") {
+		t.Errorf("expected the package list to determine the hash")
+	}
+}
@@ -1,11 +1,10 @@
 package smutje
 
 import (
-	"bufio"
 	"crypto/md5"
 	"fmt"
 	"log"
-	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -13,13 +12,20 @@ import (
 	"github.com/pkg/errors"
 )
 
+var rePasswordName = regexp.MustCompile(`^[\w.-]+$`)
+
+// storePasswordsCmd stores the passwords read from stdin in the run's private
+// directory, that is removed at the end of the run. Passwords injected before
+// are kept, unless given again. The file is replaced atomically.
+const storePasswordsCmd = `umask 077 && mkdir -p "${SMUTJE_RUN_DIR:?run directory not set}" && ` +
+	`f="$SMUTJE_RUN_DIR/passwords" && ` +
+	`{ cat -; if [ -f "$f" ]; then cat "$f"; fi; } | awk -F '\t' '!seen[$1]++' > "$f.tmp" && mv -f "$f.tmp" "$f"`
+
 type execInjectPasswordsCmd struct {
 	Passwords []string
 
 	values map[string]string
 	hash   string
-
-	cache map[string]string
 }
 
 func newInjectPasswordsCmd(args []string) (*execInjectPasswordsCmd, error) {
@@ -27,6 +33,12 @@ func newInjectPasswordsCmd(args []string) (*execInjectPasswordsCmd, error) {
 		return nil, errors.Errorf(`syntax error: password injector usage ":inject_password [<password_name>]+"`)
 	}
 
+	for _, name := range args {
+		if !rePasswordName.MatchString(name) {
+			return nil, errors.Errorf("syntax error: invalid password name %q", name)
+		}
+	}
+
 	cmd := new(execInjectPasswordsCmd)
 	cmd.Passwords = args
 	cmd.values = make(map[string]string, len(args))
@@ -43,17 +55,28 @@ func (a *execInjectPasswordsCmd) Prepare(attrs Attributes, prevHash string) (str
 		return "", errors.Wrap(err, "failed to write hash")
 	}
 
+	provider, err := secretProvider(attrs)
+	if err != nil {
+		return "", err
+	}
+
 	for _, pwdName := range a.Passwords {
-		pwd, err := a.getPassword(pwdName)
+		pwd, err := provider.Secret(pwdName)
 		if err != nil {
 			return "", err
 		}
+		// The passwords are stored in lines of tab separated name and
+		// value on the target.
+		if strings.ContainsAny(pwd, "\t\n") {
+			return "", errors.Errorf("secret %q must not contain tabs or newlines", pwdName)
+		}
 		a.values[pwdName] = pwd
+		trackSecret(pwd)
 		if _, err := hash.Write([]byte(pwd)); err != nil {
 			return "", errors.Wrap(err, "failed to write hash")
 		}
 
-		attrs["PASSWORD_"+pwdName] = fmt.Sprintf(`$(grep %s /tmp/smutje/passwords | cut -f2)`, pwdName)
+		attrs["PASSWORD_"+pwdName] = fmt.Sprintf(`$(awk -F '\t' '$1 == "%s" { v = $2 } END { print v }' "$SMUTJE_RUN_DIR/passwords")`, pwdName)
 		attrs["PASSWORD_"+pwdName+"_RAW"] = pwd
 		attrs["PASSWORD_"+pwdName+"_QUOTED"] = strconv.Quote(pwd)
 	}
@@ -63,7 +86,7 @@ func (a *execInjectPasswordsCmd) Prepare(attrs Attributes, prevHash string) (str
 }
 
 func (a *execInjectPasswordsCmd) Exec(l *log.Logger, clients gconn.Client) error {
-	sess, err := gconn.NewLoggedClient(l, clients).NewSession("/usr/bin/env", "bash", "-c", `"cat - >> /tmp/smutje/passwords"`)
+	sess, err := newLoggedClient(l, clients).NewSession("/usr/bin/env", "bash", "-c", shellQuote(storePasswordsCmd))
 	if err != nil {
 		return err
 	}
@@ -80,8 +103,8 @@ func (a *execInjectPasswordsCmd) Exec(l *log.Logger, clients gconn.Client) error
 		return err
 	}
 
-	for k, v := range a.values {
-		line := fmt.Sprintf("%s\t%s\n", k, v)
+	for _, k := range a.Passwords {
+		line := fmt.Sprintf("%s\t%s\n", k, a.values[k])
 		_, err := stdin.Write([]byte(line))
 		if err != nil {
 			stdin.Close()
@@ -96,39 +119,3 @@ func (a *execInjectPasswordsCmd) Exec(l *log.Logger, clients gconn.Client) error
 func (a *execInjectPasswordsCmd) MustExecute() bool {
 	return true
 }
-
-func (a *execInjectPasswordsCmd) getPassword(name string) (string, error) {
-	if a.cache == nil {
-		cache := map[string]string{}
-
-		fh, err := os.Open(".passwords")
-		if err != nil {
-			return "", errors.Wrap(err, "failed to read passwords")
-		}
-
-		sc := bufio.NewScanner(fh)
-		for i := 0; sc.Scan(); i++ {
-			line := sc.Text()
-			if strings.TrimSpace(line) == "" {
-				continue
-			}
-			parts := strings.Split(line, ":")
-			if len(parts) != 2 {
-				return "", errors.Errorf("invalid syntax in passwords line %d: expected 2 parts, got %d", i, len(parts))
-			}
-			name, pwd := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
-			cache[name] = pwd
-		}
-
-		if err := sc.Err(); err != nil {
-			return "", errors.Wrap(err, "failed to parse passwords file")
-		}
-
-		a.cache = cache
-	}
-	pwd, found := a.cache[name]
-	if !found {
-		return "", errors.Errorf("password %q not found", name)
-	}
-	return pwd, nil
-}
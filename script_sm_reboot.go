@@ -0,0 +1,87 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+const defaultRebootTimeout = 5 * time.Minute
+
+type execRebootCmd struct {
+	Timeout time.Duration
+
+	hash string
+}
+
+func newRebootCmd(args []string) (*execRebootCmd, error) {
+	if len(args) > 1 {
+		return nil, errors.Errorf(`syntax error: reboot usage ":reboot [<timeout>]"`)
+	}
+
+	cmd := &execRebootCmd{Timeout: defaultRebootTimeout}
+	if len(args) == 1 {
+		timeout, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "syntax error: invalid reboot timeout")
+		}
+		cmd.Timeout = timeout
+	}
+
+	return cmd, nil
+}
+
+func (a *execRebootCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execRebootCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "reboot")); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execRebootCmd) Exec(l *log.Logger, client gconn.Client) error {
+	rc, ok := findReconnectingClient(client)
+	if !ok {
+		return errors.Errorf("client doesn't support reconnecting, can't reboot")
+	}
+
+	l.Printf("rebooting the target")
+	if err := a.triggerReboot(l, client); err != nil {
+		return err
+	}
+
+	if err := rc.Reconnect(l, a.Timeout); err != nil {
+		return err
+	}
+	l.Printf("target is back")
+	return nil
+}
+
+// triggerReboot delays the reboot and sends it to the background, so that the
+// session can be closed properly before the connection is lost.
+func (a *execRebootCmd) triggerReboot(l *log.Logger, client gconn.Client) error {
+	sess, err := newLoggedClient(l, client).NewSession("/usr/bin/env", "sh", "-c", `'nohup sh -c "sleep 2; reboot" >/dev/null 2>&1 &'`)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Run()
+}
+
+func (*execRebootCmd) MustExecute() bool {
+	return false
+}
+
+func (*execRebootCmd) InterruptsConnection() bool {
+	return true
+}
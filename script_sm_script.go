@@ -0,0 +1,61 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// execScriptCmd runs a local script file on the target. The file is handled
+// like a bash code block, i.e. it is rendered with the attributes and its
+// content determines the hash.
+type execScriptCmd struct {
+	Source string
+
+	bash *bashScript
+}
+
+func newScriptCmd(path string, args []string) (*execScriptCmd, error) {
+	if len(args) == 0 {
+		return nil, errors.Errorf(`syntax error: script usage ":script <file> [<arg>...]"`)
+	}
+
+	filename, err := sourceFile(path, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &execScriptCmd{Source: filename, bash: &bashScript{ID: filename, Args: args[1:]}}, nil
+}
+
+func (a *execScriptCmd) Hash() string {
+	return a.bash.Hash()
+}
+
+func (a *execScriptCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	content, err := ioutil.ReadFile(a.Source)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read script")
+	}
+	a.bash.Script = strings.TrimSuffix(string(content), "\n")
+
+	return a.bash.Prepare(attrs, prevHash)
+}
+
+func (a *execScriptCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("running script %s", a.Source)
+	return a.bash.Exec(l, client)
+}
+
+func (*execScriptCmd) MustExecute() bool {
+	return false
+}
+
+// HandlesEnvironment is true, as the environment is applied by the bash
+// script already.
+func (*execScriptCmd) HandlesEnvironment() bool {
+	return true
+}
@@ -0,0 +1,111 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// The init system is detected on the target. Actions are only taken if the
+// service is not in the requested state already (besides restarts). The
+// resulting state is printed in any case.
+const serviceScript = `set -e
+svc=%[1]s
+action=%[2]s
+
+if command -v systemctl >/dev/null 2>&1 && [ -d /run/systemd/system ]; then
+	case "$action" in
+	enabled) systemctl is-enabled -q "$svc" || systemctl enable "$svc" ;;
+	started) systemctl is-active -q "$svc" || systemctl start "$svc" ;;
+	restarted) systemctl restart "$svc" ;;
+	stopped) ! systemctl is-active -q "$svc" || systemctl stop "$svc" ;;
+	disabled) ! systemctl is-enabled -q "$svc" || systemctl disable "$svc" ;;
+	esac
+	echo "service $svc is $(systemctl is-enabled "$svc" 2>/dev/null || true) and $(systemctl is-active "$svc" || true)"
+elif command -v svcadm >/dev/null 2>&1; then
+	case "$action" in
+	enabled|started) svcadm enable -s "$svc" ;;
+	restarted) svcadm restart "$svc" ;;
+	stopped) svcadm disable -s -t "$svc" ;;
+	disabled) svcadm disable -s "$svc" ;;
+	esac
+	echo "service $svc is $(svcs -H -o state "$svc")"
+elif [ -x "/etc/init.d/$svc" ]; then
+	case "$action" in
+	enabled)
+		if command -v update-rc.d >/dev/null 2>&1; then
+			update-rc.d "$svc" defaults
+		elif command -v chkconfig >/dev/null 2>&1; then
+			chkconfig "$svc" on
+		else
+			echo "no tool found to enable service $svc" >&2
+			exit 1
+		fi
+		;;
+	started) "/etc/init.d/$svc" status >/dev/null 2>&1 || "/etc/init.d/$svc" start ;;
+	restarted) "/etc/init.d/$svc" restart ;;
+	stopped) ! "/etc/init.d/$svc" status >/dev/null 2>&1 || "/etc/init.d/$svc" stop ;;
+	disabled)
+		if command -v update-rc.d >/dev/null 2>&1; then
+			update-rc.d "$svc" disable
+		elif command -v chkconfig >/dev/null 2>&1; then
+			chkconfig "$svc" off
+		else
+			echo "no tool found to disable service $svc" >&2
+			exit 1
+		fi
+		;;
+	esac
+	echo "service $svc: $("/etc/init.d/$svc" status 2>&1 | head -n 1 || true)"
+else
+	echo "no supported init system found for service $svc" >&2
+	exit 1
+fi
+`
+
+type execServiceCmd struct {
+	Name  string
+	State string
+
+	hash string
+}
+
+func newServiceCmd(args []string) (*execServiceCmd, error) {
+	if len(args) != 2 {
+		return nil, errors.Errorf(`syntax error: service usage ":service <name> enabled|started|restarted|stopped|disabled"`)
+	}
+
+	switch args[1] {
+	case "enabled", "started", "restarted", "stopped", "disabled":
+	default:
+		return nil, errors.Errorf("syntax error: invalid service state %q (expected enabled, started, restarted, stopped or disabled)", args[1])
+	}
+
+	return &execServiceCmd{Name: args[0], State: args[1]}, nil
+}
+
+func (a *execServiceCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execServiceCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "service" + a.Name + "\n" + a.State)); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execServiceCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("ensuring service %q is %s", a.Name, a.State)
+	script := fmt.Sprintf(serviceScript, shellQuote(a.Name), a.State)
+	return execRemoteScript(l, client, script)
+}
+
+func (*execServiceCmd) MustExecute() bool {
+	return false
+}
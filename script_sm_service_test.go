@@ -0,0 +1,31 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceCmd(t *testing.T) {
+	tt := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"nginx", "started"}, ""},
+		{[]string{"nginx", "stopped"}, ""},
+		{[]string{"nginx", "disabled"}, ""},
+		{[]string{"nginx", "running"}, "invalid service state"},
+		{[]string{"nginx"}, "service usage"},
+	}
+
+	for i, tti := range tt {
+		_, err := newServiceCmd(tti.args)
+		switch {
+		case tti.err == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.err != "" && err == nil:
+			t.Errorf("%d: expected error %q, got none", i, tti.err)
+		case tti.err != "" && !strings.Contains(err.Error(), tti.err):
+			t.Errorf("%d: expected error %q, got %q", i, tti.err, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+type execSymlinkCmd struct {
+	Source string
+	Target string
+	Meta   *fileMetadata
+
+	hash string
+}
+
+func newSymlinkCmd(args []string) (*execSymlinkCmd, error) {
+	meta, args := parseFileMetadata(args)
+
+	if len(args) != 2 {
+		return nil, errors.Errorf(`syntax error: symlink usage ":symlink <source> <target> [owner=<user>] [group=<group>]"`)
+	}
+
+	if meta.Mode != "" {
+		return nil, errors.Errorf("syntax error: mode can't be set on symlinks")
+	}
+
+	return &execSymlinkCmd{Source: args[0], Target: args[1], Meta: meta}, nil
+}
+
+func (a *execSymlinkCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execSymlinkCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "symlink" + a.Source + a.Target + a.Meta.Hash())); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execSymlinkCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("linking %q to %q", a.Target, a.Source)
+	cmd := parentDirCmd(a.Target) + " && ln -sfn " + shellQuote(a.Source) + " " + shellQuote(a.Target) + a.Meta.Commands(a.Target, true)
+	sess, err := newLoggedClient(l, client).NewSession("/usr/bin/env", "sh", "-c", shellQuote(cmd))
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Run()
+}
+
+func (*execSymlinkCmd) MustExecute() bool {
+	return false
+}
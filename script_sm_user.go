@@ -0,0 +1,211 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// The account is created if missing, otherwise only the attributes differing
+// are modified. Keys are only appended to the authorized_keys file, if they are
+// not contained already.
+const userScript = `set -e
+name=%[1]s uid=%[2]s groups=%[3]s shell=%[4]s home=%[5]s keys=%[6]s
+changed=no
+
+if id "$name" >/dev/null 2>&1; then
+	entry=$(getent passwd "$name")
+	if [ -n "$uid" ] && [ "$(echo "$entry" | cut -d: -f3)" != "$uid" ]; then
+		usermod -u "$uid" "$name"; changed=yes
+	fi
+	if [ -n "$shell" ] && [ "$(echo "$entry" | cut -d: -f7)" != "$shell" ]; then
+		usermod -s "$shell" "$name"; changed=yes
+	fi
+	if [ -n "$home" ] && [ "$(echo "$entry" | cut -d: -f6)" != "$home" ]; then
+		usermod -d "$home" -m "$name"; changed=yes
+	fi
+	if [ -n "$groups" ]; then
+		primary=$(id -gn "$name")
+		cur=$(id -Gn "$name" | tr ' ' '\n' | grep -vx "$primary" | sort | tr '\n' ',')
+		exp=$(echo "$groups" | tr ',' '\n' | grep -vx "$primary" | sort | tr '\n' ',')
+		if [ "$cur" != "$exp" ]; then
+			usermod -G "$groups" "$name"; changed=yes
+		fi
+	fi
+else
+	set -- -m
+	if [ -n "$uid" ]; then set -- "$@" -u "$uid"; fi
+	if [ -n "$groups" ]; then set -- "$@" -G "$groups"; fi
+	if [ -n "$shell" ]; then set -- "$@" -s "$shell"; fi
+	if [ -n "$home" ]; then set -- "$@" -d "$home"; fi
+	useradd "$@" "$name"; changed=yes
+fi
+
+if [ -n "$keys" ]; then
+	dir="$(getent passwd "$name" | cut -d: -f6)/.ssh"
+	group=$(id -gn "$name")
+	if [ ! -d "$dir" ]; then
+		mkdir -p "$dir" && chmod 0700 "$dir" && chown "$name:$group" "$dir"
+	fi
+	file="$dir/authorized_keys"
+	if [ ! -e "$file" ]; then
+		touch "$file" && chmod 0600 "$file" && chown "$name:$group" "$file"
+	fi
+	echo "$keys" | while IFS= read -r key; do
+		if [ -n "$key" ] && ! grep -qxF "$key" "$file"; then
+			echo "$key" >> "$file"
+			echo "added key to $file"
+		fi
+	done
+fi
+
+echo "user $name: $(id "$name") (changed: $changed)"
+`
+
+const groupScript = `set -e
+name=%[1]s gid=%[2]s
+
+if entry=$(getent group "$name"); then
+	if [ -n "$gid" ] && [ "$(echo "$entry" | cut -d: -f3)" != "$gid" ]; then
+		groupmod -g "$gid" "$name"
+		echo "changed"
+	else
+		echo "unchanged"
+	fi
+else
+	if [ -n "$gid" ]; then
+		groupadd -g "$gid" "$name"
+	else
+		groupadd "$name"
+	fi
+	echo "created"
+fi
+`
+
+type execUserCmd struct {
+	Name    string
+	UID     string
+	Groups  string
+	Shell   string
+	Home    string
+	SSHKeys []string
+
+	keys string
+	hash string
+}
+
+func newUserCmd(path string, args []string) (*execUserCmd, error) {
+	// The ssh_key option can be given multiple times, so it is handled
+	// separately.
+	var keys []string
+	rest := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "ssh_key=") {
+			rest = append(rest, arg)
+			continue
+		}
+		for _, key := range strings.Split(strings.TrimPrefix(arg, "ssh_key="), ",") {
+			if key == "" {
+				continue
+			}
+			if key[0] != '/' {
+				key = filepath.Join(path, key)
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	opts, args := parseOptions(rest, "uid", "groups", "shell", "home")
+	if len(args) != 1 || strings.Contains(args[0], "=") {
+		return nil, errors.Errorf(`syntax error: user usage ":user <name> [uid=<uid>] [groups=<group>,...] [shell=<shell>] [home=<dir>] [ssh_key=<file>,...]"`)
+	}
+
+	return &execUserCmd{
+		Name:    args[0],
+		UID:     opts["uid"],
+		Groups:  opts["groups"],
+		Shell:   opts["shell"],
+		Home:    opts["home"],
+		SSHKeys: keys,
+	}, nil
+}
+
+func (a *execUserCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execUserCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	keys := make([]string, 0, len(a.SSHKeys))
+	for _, fname := range a.SSHKeys {
+		content, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read ssh key")
+		}
+		if key := strings.TrimSpace(string(content)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	a.keys = strings.Join(keys, "\n")
+
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "user" + strings.Join([]string{a.Name, a.UID, a.Groups, a.Shell, a.Home, a.keys}, "\n"))); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execUserCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("ensuring user %q exists", a.Name)
+	script := fmt.Sprintf(userScript, shellQuote(a.Name), shellQuote(a.UID), shellQuote(a.Groups),
+		shellQuote(a.Shell), shellQuote(a.Home), shellQuote(a.keys))
+	return execRemoteScript(l, client, script)
+}
+
+func (*execUserCmd) MustExecute() bool {
+	return false
+}
+
+type execGroupCmd struct {
+	Name string
+	GID  string
+
+	hash string
+}
+
+func newGroupCmd(args []string) (*execGroupCmd, error) {
+	opts, args := parseOptions(args, "gid")
+	if len(args) != 1 || strings.Contains(args[0], "=") {
+		return nil, errors.Errorf(`syntax error: group usage ":group <name> [gid=<gid>]"`)
+	}
+	return &execGroupCmd{Name: args[0], GID: opts["gid"]}, nil
+}
+
+func (a *execGroupCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execGroupCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "group" + a.Name + "\n" + a.GID)); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execGroupCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("ensuring group %q exists", a.Name)
+	script := fmt.Sprintf(groupScript, shellQuote(a.Name), shellQuote(a.GID))
+	return execRemoteScript(l, client, script)
+}
+
+func (*execGroupCmd) MustExecute() bool {
+	return false
+}
@@ -0,0 +1,109 @@
+package smutje
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUserCmd(t *testing.T) {
+	tt := []struct {
+		args    []string
+		exp     execUserCmd
+		expKeys []string
+		err     string
+	}{
+		{[]string{"deploy"}, execUserCmd{Name: "deploy"}, nil, ""},
+		{
+			[]string{"deploy", "uid=1001", "groups=sudo,adm", "shell=/bin/bash", "home=/srv/deploy"},
+			execUserCmd{Name: "deploy", UID: "1001", Groups: "sudo,adm", Shell: "/bin/bash", Home: "/srv/deploy"},
+			nil, "",
+		},
+		{[]string{"deploy", "ssh_key=a.pub"}, execUserCmd{Name: "deploy"}, []string{"/res/a.pub"}, ""},
+		{[]string{"deploy", "ssh_key=a.pub,/keys/b.pub"}, execUserCmd{Name: "deploy"}, []string{"/res/a.pub", "/keys/b.pub"}, ""},
+		{[]string{"ssh_key=a.pub", "deploy", "ssh_key=b.pub"}, execUserCmd{Name: "deploy"}, []string{"/res/a.pub", "/res/b.pub"}, ""},
+		{[]string{}, execUserCmd{}, nil, "user usage"},
+		{[]string{"deploy", "admin"}, execUserCmd{}, nil, "user usage"},
+		{[]string{"deploy", "gid=1001"}, execUserCmd{}, nil, "user usage"},
+		{[]string{"uid=1001"}, execUserCmd{}, nil, "user usage"},
+	}
+
+	for i, tti := range tt {
+		cmd, err := newUserCmd("/res", tti.args)
+		switch {
+		case tti.err == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.err != "" && err == nil:
+			t.Errorf("%d: expected error %q, got none", i, tti.err)
+		case tti.err != "" && !strings.Contains(err.Error(), tti.err):
+			t.Errorf("%d: expected error %q, got %q", i, tti.err, err)
+		case tti.err != "":
+			// expected error received
+		case cmd.Name != tti.exp.Name || cmd.UID != tti.exp.UID || cmd.Groups != tti.exp.Groups ||
+			cmd.Shell != tti.exp.Shell || cmd.Home != tti.exp.Home:
+			t.Errorf("%d: expected %+v, got %+v", i, tti.exp, *cmd)
+		case strings.Join(cmd.SSHKeys, " ") != strings.Join(tti.expKeys, " "):
+			t.Errorf("%d: expected keys %q, got %q", i, tti.expKeys, cmd.SSHKeys)
+		}
+	}
+}
+
+func TestUserCmdSSHKeys(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.pub": "ssh-ed25519 AAAA a\n", "b.pub": "ssh-ed25519 BBBB b\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+	}
+
+	cmd, err := newUserCmd(dir, []string{"deploy", "ssh_key=a.pub,b.pub"})
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if _, err := cmd.Prepare(Attributes{}, ""); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if exp := "ssh-ed25519 AAAA a\nssh-ed25519 BBBB b"; cmd.keys != exp {
+		t.Errorf("expected keys %q, got %q", exp, cmd.keys)
+	}
+
+	cmd, err = newUserCmd(dir, []string{"deploy", "ssh_key=missing.pub"})
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if _, err := cmd.Prepare(Attributes{}, ""); err == nil {
+		t.Errorf("expected an error for a missing key file, got none")
+	}
+}
+
+func TestGroupCmd(t *testing.T) {
+	tt := []struct {
+		args   []string
+		expGID string
+		err    string
+	}{
+		{[]string{"deploy"}, "", ""},
+		{[]string{"deploy", "gid=1001"}, "1001", ""},
+		{[]string{"gid=1001", "deploy"}, "1001", ""},
+		{[]string{}, "", "group usage"},
+		{[]string{"gid=1001"}, "", "group usage"},
+		{[]string{"deploy", "uid=1001"}, "", "group usage"},
+	}
+
+	for i, tti := range tt {
+		cmd, err := newGroupCmd(tti.args)
+		switch {
+		case tti.err == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.err != "" && err == nil:
+			t.Errorf("%d: expected error %q, got none", i, tti.err)
+		case tti.err != "" && !strings.Contains(err.Error(), tti.err):
+			t.Errorf("%d: expected error %q, got %q", i, tti.err, err)
+		case tti.err != "":
+			// expected error received
+		case cmd.Name != "deploy" || cmd.GID != tti.expGID:
+			t.Errorf("%d: expected group deploy with gid %q, got %+v", i, tti.expGID, *cmd)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package smutje
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWaitTimeout  = time.Minute
+	defaultWaitInterval = time.Second
+
+	waitTimeoutMarker = "SMUTJE_WAIT_TIMEOUT"
+)
+
+const waitForScript = `deadline=$(( $(date +%%s) + %[1]d ))
+until %[2]s; do
+	if [ "$(date +%%s)" -ge "${deadline}" ]; then
+		echo %[3]s
+		exit 1
+	fi
+	sleep %[4]d
+done
+`
+
+var reWaitHost = regexp.MustCompile(`^[\w:][\w.:-]*$`)
+
+type execWaitForCmd struct {
+	Port     string
+	Host     string
+	File     string
+	Cmd      string
+	Timeout  time.Duration
+	Interval time.Duration
+
+	hash string
+}
+
+func newWaitForCmd(args []string) (*execWaitForCmd, error) {
+	opts, args := parseOptions(args, "port", "host", "file", "cmd", "timeout", "interval")
+	if len(args) != 0 {
+		return nil, errors.Errorf(`syntax error: wait for usage ":wait_for port=<port> [host=<host>]|file=<path>|cmd=<command> [timeout=<duration>] [interval=<duration>]"`)
+	}
+
+	cmd := &execWaitForCmd{
+		Port: opts["port"], Host: opts["host"], File: opts["file"], Cmd: opts["cmd"],
+		Timeout: defaultWaitTimeout, Interval: defaultWaitInterval,
+	}
+
+	cnt := 0
+	for _, o := range []string{cmd.Port, cmd.File, cmd.Cmd} {
+		if o != "" {
+			cnt++
+		}
+	}
+	if cnt != 1 {
+		return nil, errors.Errorf("syntax error: exactly one of port, file, or cmd must be given")
+	}
+
+	if cmd.Port != "" {
+		if _, err := strconv.Atoi(cmd.Port); err != nil {
+			return nil, errors.Errorf("syntax error: invalid port %q", cmd.Port)
+		}
+		if cmd.Host == "" {
+			cmd.Host = "127.0.0.1"
+		}
+		if !reWaitHost.MatchString(cmd.Host) {
+			return nil, errors.Errorf("syntax error: invalid host %q", cmd.Host)
+		}
+	} else if cmd.Host != "" {
+		return nil, errors.Errorf("syntax error: host is only supported with port")
+	}
+
+	var err error
+	if t, ok := opts["timeout"]; ok {
+		if cmd.Timeout, err = time.ParseDuration(t); err != nil {
+			return nil, errors.Wrap(err, "syntax error: invalid timeout")
+		}
+	}
+	if i, ok := opts["interval"]; ok {
+		if cmd.Interval, err = time.ParseDuration(i); err != nil {
+			return nil, errors.Wrap(err, "syntax error: invalid interval")
+		}
+	}
+	if cmd.Interval < time.Second || cmd.Interval%time.Second != 0 {
+		return nil, errors.Errorf("syntax error: interval must be given in whole seconds (at least 1s)")
+	}
+
+	return cmd, nil
+}
+
+func (a *execWaitForCmd) Hash() string {
+	return a.hash
+}
+
+func (a *execWaitForCmd) Prepare(attrs Attributes, prevHash string) (string, error) {
+	hash := md5.New()
+	if _, err := hash.Write([]byte(prevHash + "wait_for" + a.String())); err != nil {
+		return "", errors.Wrap(err, "failed to create command hash")
+	}
+	a.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	return a.hash, nil
+}
+
+func (a *execWaitForCmd) String() string {
+	switch {
+	case a.Port != "":
+		return "port " + a.Host + ":" + a.Port
+	case a.File != "":
+		return "file " + a.File
+	default:
+		return "command " + strconv.Quote(a.Cmd)
+	}
+}
+
+func (a *execWaitForCmd) check() string {
+	switch {
+	case a.Port != "":
+		return fmt.Sprintf(`(exec 3<>%s) 2>/dev/null`, shellQuote("/dev/tcp/"+a.Host+"/"+a.Port))
+	case a.File != "":
+		return "test -e " + shellQuote(a.File)
+	default:
+		return "sh -c " + shellQuote(a.Cmd) + " >/dev/null 2>&1"
+	}
+}
+
+func (a *execWaitForCmd) Exec(l *log.Logger, client gconn.Client) error {
+	l.Printf("waiting for %s", a)
+
+	script := fmt.Sprintf(waitForScript, int(a.Timeout.Seconds()), a.check(), waitTimeoutMarker, int(a.Interval.Seconds()))
+	output, err := execRemoteScriptOutput(l, client, script)
+	if strings.Contains(output, waitTimeoutMarker) {
+		return errors.Errorf("timeout waiting for %s after %s", a, a.Timeout)
+	}
+	return err
+}
+
+func (*execWaitForCmd) MustExecute() bool {
+	return true
+}
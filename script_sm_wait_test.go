@@ -0,0 +1,43 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWaitForCmd(t *testing.T) {
+	tt := []struct {
+		args     []string
+		expCheck string
+		err      string
+	}{
+		{[]string{"port=22"}, `(exec 3<>'/dev/tcp/127.0.0.1/22') 2>/dev/null`, ""},
+		{[]string{"port=5432", "host=db.example.org"}, `(exec 3<>'/dev/tcp/db.example.org/5432') 2>/dev/null`, ""},
+		{[]string{"port=22", "host=::1"}, `(exec 3<>'/dev/tcp/::1/22') 2>/dev/null`, ""},
+		{[]string{"file=/tmp/a b"}, `test -e '/tmp/a b'`, ""},
+		{[]string{"cmd=curl -fs localhost", "interval=2s"}, `sh -c 'curl -fs localhost' >/dev/null 2>&1`, ""},
+		{[]string{"port=22", "host=a;reboot"}, "", "invalid host"},
+		{[]string{"port=22", "host=-oProxy"}, "", "invalid host"},
+		{[]string{"port=ssh"}, "", "invalid port"},
+		{[]string{"file=/tmp/a", "host=a"}, "", "host is only supported with port"},
+		{[]string{"file=/tmp/a", "port=22"}, "", "exactly one of"},
+		{[]string{"file=/tmp/a", "interval=500ms"}, "", "whole seconds"},
+		{[]string{"file=/tmp/a", "interval=1500ms"}, "", "whole seconds"},
+		{[]string{"file=/tmp/a", "timeout=soon"}, "", "invalid timeout"},
+		{[]string{"file=/tmp/a", "extra"}, "", "wait for usage"},
+	}
+
+	for i, tti := range tt {
+		cmd, err := newWaitForCmd(tti.args)
+		switch {
+		case tti.err == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.err != "" && err == nil:
+			t.Errorf("%d: expected error %q, got none", i, tti.err)
+		case tti.err != "" && !strings.Contains(err.Error(), tti.err):
+			t.Errorf("%d: expected error %q, got %q", i, tti.err, err)
+		case tti.err == "" && cmd.check() != tti.expCheck:
+			t.Errorf("%d: expected check %q, got %q", i, tti.expCheck, cmd.check())
+		}
+	}
+}
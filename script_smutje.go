@@ -12,7 +12,10 @@ type smutjeScript struct {
 	ID         string
 	Path       string
 	rawCommand string
-	Command    smScript
+	Command    Command
+
+	client gconn.Client
+	env    []string
 }
 
 func (s *smutjeScript) Hash() string {
@@ -24,17 +27,47 @@ func (s *smutjeScript) Prepare(attrs Attributes, prevHash string) (string, error
 		return "", err
 	}
 
-	return s.Command.Prepare(attrs, prevHash)
+	if tp, ok := s.Command.(targetPreparer); ok {
+		tp.SetClient(s.client)
+	}
+
+	s.env = nil
+	if handlesEnvironment(s.Command) {
+		return s.Command.Prepare(attrs, prevHash)
+	}
+
+	env, err := attrs.Environment()
+	if err != nil {
+		return "", err
+	}
+	s.env = env
+
+	return s.Command.Prepare(attrs, prevHash+strings.Join(env, "\n"))
 }
 
 func (s *smutjeScript) Exec(l *log.Logger, client gconn.Client) error {
-	return s.Command.Exec(l, client)
+	return s.Command.Exec(l, newEnvClient(client, s.env))
 }
 
 func (s *smutjeScript) MustExecute() bool {
 	return s.Command.MustExecute()
 }
 
+func (s *smutjeScript) SetClient(client gconn.Client) {
+	s.client = client
+}
+
+func (s *smutjeScript) Exports() Attributes {
+	if es, ok := s.Command.(exportingScript); ok {
+		return es.Exports()
+	}
+	return nil
+}
+
+func (s *smutjeScript) InterruptsConnection() bool {
+	return interruptsConnection(s.Command)
+}
+
 func (s *smutjeScript) initCommands(attrs Attributes) error {
 	raw, err := renderString(s.ID, s.rawCommand, attrs)
 	if err != nil {
@@ -45,17 +78,20 @@ func (s *smutjeScript) initCommands(attrs Attributes) error {
 	if len(args) == 0 {
 		return errors.Errorf("empty command received")
 	}
-	switch strings.ToLower(args[0]) {
-	case ":write_file":
-		s.Command, err = newExecWriteFileCmd(s.Path, args[1:])
-	case ":write_template":
-		s.Command, err = newExecWriteTemplateCmd(s.Path, args[1:])
-	case ":jenkins_artifact":
-		s.Command, err = newJenkinsArtifactCmd(args[1:])
-	case ":inject_passwords":
-		s.Command, err = newInjectPasswordsCmd(args[1:])
-	default:
+	rest := strings.TrimPrefix(strings.TrimSpace(raw), args[0])
+
+	factory, ok := lookupCommand(args[0])
+	if !ok || !strings.HasPrefix(args[0], ":") {
 		return errors.Errorf("command %s unknown", args[0])
 	}
-	return err
+
+	cmd, err := factory(CommandArgs{Path: s.Path, Args: args[1:], Raw: rest})
+	switch {
+	case err != nil:
+		return err
+	case cmd == nil:
+		return errors.Errorf("command %s created no command", args[0])
+	}
+	s.Command = cmd
+	return nil
 }
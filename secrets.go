@@ -0,0 +1,323 @@
+package smutje
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A SecretProvider looks up secrets (like the passwords injected using the
+// `:inject_passwords` command) by name.
+type SecretProvider interface {
+	// Name describes the provider in messages.
+	Name() string
+	// Secret returns the secret with the given name. An error is returned if
+	// the secret doesn't exist.
+	Secret(name string) (string, error)
+}
+
+// DefaultSecretsFile is the file secrets are read from, if no other is
+// configured.
+const DefaultSecretsFile = ".passwords"
+
+const (
+	defaultSecretsEnvPrefix = "SMUTJE_SECRET_"
+	secretsConfigFile       = ".smutje.conf"
+)
+
+// secretsConfigKeys are the attributes configuring the secret provider. They
+// can be given in the resource or in the config file.
+var secretsConfigKeys = []string{"Secrets", "SecretsFile", "SecretsEnvPrefix", "SecretsCommand", "SecretsKeyFile"}
+
+var (
+	secretProvidersMu sync.Mutex
+	secretProviders   = map[string]SecretProvider{}
+)
+
+// secretProvider returns the provider configured by the given attributes and
+// the config file in the working directory (see secretsConfig). Providers are
+// shared for equal configurations, so that secrets are only read once.
+func secretProvider(attrs Attributes) (SecretProvider, error) {
+	cfg, err := secretsConfig(attrs, secretsConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(cfg))
+	for k, v := range cfg {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	id := strings.Join(keys, "\n")
+
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+
+	if p, ok := secretProviders[id]; ok {
+		return p, nil
+	}
+
+	p, err := newSecretProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	secretProviders[id] = p
+	return p, nil
+}
+
+// secretsConfig reads the secrets configuration from the given file and
+// overwrites each setting also given in the attributes.
+func secretsConfig(attrs Attributes, filename string) (map[string]string, error) {
+	cfg, err := readSecretsConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range secretsConfigKeys {
+		if v, ok := attrs[key]; ok {
+			cfg[key] = v
+		}
+	}
+	return cfg, nil
+}
+
+func newSecretProvider(cfg map[string]string) (SecretProvider, error) {
+	switch typ := cfg["Secrets"]; typ {
+	case "", "file":
+		filename := cfg["SecretsFile"]
+		if filename == "" {
+			filename = DefaultSecretsFile
+		}
+		return &fileSecretProvider{filename: filename, keyFile: cfg["SecretsKeyFile"]}, nil
+	case "env":
+		prefix, ok := cfg["SecretsEnvPrefix"]
+		if !ok {
+			prefix = defaultSecretsEnvPrefix
+		}
+		return &envSecretProvider{prefix: prefix}, nil
+	case "command":
+		if cfg["SecretsCommand"] == "" {
+			return nil, errors.Errorf("secrets command provider requires the SecretsCommand attribute")
+		}
+		return &commandSecretProvider{command: cfg["SecretsCommand"]}, nil
+	case "encrypted":
+		if cfg["SecretsFile"] == "" {
+			return nil, errors.Errorf("encrypted secrets provider requires the SecretsFile attribute")
+		}
+		return &encryptedSecretProvider{filename: cfg["SecretsFile"], keyFile: cfg["SecretsKeyFile"]}, nil
+	default:
+		return nil, errors.Errorf("invalid value for attribute Secrets: %q (expected file, env, command or encrypted)", typ)
+	}
+}
+
+// readSecretsConfig reads the secrets configuration from the given file. A
+// missing file results in an empty configuration.
+func readSecretsConfig(filename string) (map[string]string, error) {
+	fh, err := os.Open(filename)
+	switch {
+	case os.IsNotExist(err):
+		return map[string]string{}, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read secrets config")
+	}
+	defer fh.Close()
+
+	cfg, err := ParseSecrets(fh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", filename)
+	}
+
+	for key := range cfg {
+		valid := false
+		for _, k := range secretsConfigKeys {
+			valid = valid || k == key
+		}
+		if !valid {
+			return nil, errors.Errorf("unknown key %q in %s", key, filename)
+		}
+	}
+	return cfg, nil
+}
+
+// ParseSecrets parses secrets given in lines of the form `name: value`. Empty
+// lines are ignored.
+func ParseSecrets(r io.Reader) (map[string]string, error) {
+	secrets := map[string]string{}
+
+	sc := bufio.NewScanner(r)
+	for i := 1; sc.Scan(); i++ {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid syntax in line %d: expected `name: value`", i)
+		}
+		secrets[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return secrets, errors.Wrap(sc.Err(), "failed to scan secrets")
+}
+
+// FormatSecrets returns the secrets in the form read by ParseSecrets, sorted by
+// name.
+func FormatSecrets(secrets map[string]string) []byte {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(nil)
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s: %s\n", name, secrets[name])
+	}
+	return buf.Bytes()
+}
+
+func lookupSecret(p SecretProvider, secrets map[string]string, name string) (string, error) {
+	s, ok := secrets[name]
+	if !ok {
+		return "", errors.Errorf("secret %q not found in %s", name, p.Name())
+	}
+	return s, nil
+}
+
+// fileSecretProvider reads the secrets from a file. Encrypted files are
+// detected and decrypted transparently.
+type fileSecretProvider struct {
+	filename string
+	keyFile  string
+
+	once    sync.Once
+	secrets map[string]string
+	err     error
+}
+
+func (p *fileSecretProvider) Name() string {
+	return fmt.Sprintf("secrets file %q", p.filename)
+}
+
+func (p *fileSecretProvider) Secret(name string) (string, error) {
+	p.once.Do(func() {
+		data, err := ioutil.ReadFile(p.filename)
+		if err != nil {
+			p.err = errors.Wrapf(err, "failed to read %s", p.Name())
+			return
+		}
+
+		if isEncryptedSecrets(data) {
+			var key []byte
+			if key, p.err = SecretsPassphrase(p.keyFile); p.err != nil {
+				return
+			}
+			if data, p.err = decryptSecrets(data, key); p.err != nil {
+				p.err = errors.Wrapf(p.err, "failed to read %s", p.Name())
+				return
+			}
+		}
+
+		p.secrets, p.err = ParseSecrets(bytes.NewReader(data))
+		p.err = errors.Wrapf(p.err, "failed to parse %s", p.Name())
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+	return lookupSecret(p, p.secrets, name)
+}
+
+// envSecretProvider reads the secrets from environment variables. The name of
+// the variable is the secret's name with the prefix added.
+type envSecretProvider struct {
+	prefix string
+}
+
+func (p *envSecretProvider) Name() string {
+	return fmt.Sprintf("environment (prefix %q)", p.prefix)
+}
+
+func (p *envSecretProvider) Secret(name string) (string, error) {
+	s, ok := os.LookupEnv(p.prefix + name)
+	if !ok {
+		return "", errors.Errorf("secret %q not found in %s", name, p.Name())
+	}
+	return s, nil
+}
+
+// commandSecretProvider runs a local command for each secret. The secret's
+// name is added as argument and the first line of the output is the secret.
+// This allows to use tools like `pass show`.
+type commandSecretProvider struct {
+	command string
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func (p *commandSecretProvider) Name() string {
+	return fmt.Sprintf("secrets command %q", p.command)
+}
+
+func (p *commandSecretProvider) Secret(name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s, ok := p.cache[name]; ok {
+		return s, nil
+	}
+
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.Command("bash", "-c", p.command+" "+shellQuote(name))
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "secret %q not found using %s: %s", name, p.Name(), strings.TrimSpace(stderr.String()))
+	}
+
+	s := strings.SplitN(string(out), "\n", 2)[0]
+	if p.cache == nil {
+		p.cache = map[string]string{}
+	}
+	p.cache[name] = s
+	return s, nil
+}
+
+// encryptedSecretProvider reads the secrets from an encrypted file (see
+// decryptSecrets for details).
+type encryptedSecretProvider struct {
+	filename string
+	keyFile  string
+
+	once    sync.Once
+	secrets map[string]string
+	err     error
+}
+
+func (p *encryptedSecretProvider) Name() string {
+	return fmt.Sprintf("encrypted secrets file %q", p.filename)
+}
+
+func (p *encryptedSecretProvider) Secret(name string) (string, error) {
+	p.once.Do(func() {
+		var key []byte
+		if key, p.err = SecretsPassphrase(p.keyFile); p.err != nil {
+			return
+		}
+
+		p.secrets, p.err = ReadSecretsFile(p.filename, key)
+		p.err = errors.Wrapf(p.err, "failed to read %s", p.Name())
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+	return lookupSecret(p, p.secrets, name)
+}
@@ -0,0 +1,139 @@
+package smutje
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted secrets files consist of a header line followed by the base64
+// encoded salt, nonce and the sealed content (the same `name: value` lines as
+// in the plain text file). The key is derived from the passphrase using
+// scrypt with the salt, the content is sealed using NaCl's secretbox.
+const encryptedSecretsHeader = "smutje-secrets:v1\n"
+
+const (
+	secretsSaltSize  = 16
+	secretsNonceSize = 24
+)
+
+const (
+	envSecretsPassphrase = "SMUTJE_SECRETS_PASSPHRASE"
+	envSecretsKeyFile    = "SMUTJE_SECRETS_KEY_FILE"
+)
+
+// SecretsPassphrase returns the passphrase for encrypted secrets files. It is
+// read from the given key file, the key file set in the environment, or
+// directly from the environment (in that order).
+func SecretsPassphrase(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv(envSecretsKeyFile)
+	}
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read secrets key file")
+		}
+		return bytes.TrimSpace(key), nil
+	}
+
+	if pass, ok := os.LookupEnv(envSecretsPassphrase); ok {
+		return []byte(pass), nil
+	}
+	return nil, errors.Errorf("no key for encrypted secrets given (set %s or %s)", envSecretsKeyFile, envSecretsPassphrase)
+}
+
+func deriveSecretsKey(passphrase, salt []byte) (*[32]byte, error) {
+	raw, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+	key := new([32]byte)
+	copy(key[:], raw)
+	return key, nil
+}
+
+// ReadSecretsFile reads the secrets from the given encrypted file.
+func ReadSecretsFile(filename string, passphrase []byte) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := decryptSecrets(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSecrets(bytes.NewReader(plain))
+}
+
+// WriteSecretsFile writes the secrets encrypted to the given file. The file is
+// replaced atomically.
+func WriteSecretsFile(filename string, passphrase []byte, secrets map[string]string) error {
+	data, err := encryptSecrets(FormatSecrets(secrets), passphrase)
+	if err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write secrets file")
+	}
+	return errors.Wrap(os.Rename(tmp, filename), "failed to replace secrets file")
+}
+
+func encryptSecrets(plain, passphrase []byte) ([]byte, error) {
+	raw := make([]byte, secretsSaltSize+secretsNonceSize)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, errors.Wrap(err, "failed to create salt and nonce")
+	}
+
+	key, err := deriveSecretsKey(passphrase, raw[:secretsSaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [secretsNonceSize]byte
+	copy(nonce[:], raw[secretsSaltSize:])
+	sealed := secretbox.Seal(raw, plain, &nonce, key)
+
+	return []byte(encryptedSecretsHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func isEncryptedSecrets(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedSecretsHeader))
+}
+
+func decryptSecrets(data, passphrase []byte) ([]byte, error) {
+	if !isEncryptedSecrets(data) {
+		return nil, errors.Errorf("not an encrypted secrets file")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(encryptedSecretsHeader):])))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode secrets")
+	}
+	if len(raw) < secretsSaltSize+secretsNonceSize+secretbox.Overhead {
+		return nil, errors.Errorf("encrypted secrets are truncated")
+	}
+
+	key, err := deriveSecretsKey(passphrase, raw[:secretsSaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [secretsNonceSize]byte
+	copy(nonce[:], raw[secretsSaltSize:secretsSaltSize+secretsNonceSize])
+	plain, ok := secretbox.Open(nil, raw[secretsSaltSize+secretsNonceSize:], &nonce, key)
+	if !ok {
+		return nil, errors.Errorf("failed to decrypt secrets (wrong key?)")
+	}
+	return plain, nil
+}
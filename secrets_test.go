@@ -0,0 +1,213 @@
+package smutje
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretProviders(t *testing.T) {
+	dir := t.TempDir()
+
+	plainFile := filepath.Join(dir, "passwords")
+	if err := ioutil.WriteFile(plainFile, []byte("db: se:cret\n\nadmin : foo\n"), 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	data, err := encryptSecrets([]byte("db: encrypted\n"), []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	encFile := filepath.Join(dir, "secrets.enc")
+	if err := ioutil.WriteFile(encFile, data, 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte("passphrase\n"), 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	wrongKeyFile := filepath.Join(dir, "wrong_key")
+	if err := ioutil.WriteFile(wrongKeyFile, []byte("wrong"), 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	os.Setenv("SMUTJE_TEST_SECRET_db", "from env")
+	defer os.Unsetenv("SMUTJE_TEST_SECRET_db")
+
+	tt := []struct {
+		cfg    map[string]string
+		name   string
+		exp    string
+		expErr string
+	}{
+		{map[string]string{"SecretsFile": plainFile}, "db", "se:cret", ""},
+		{map[string]string{"Secrets": "file", "SecretsFile": plainFile}, "admin", "foo", ""},
+		{map[string]string{"SecretsFile": plainFile}, "unknown", "", "not found in secrets file"},
+		{map[string]string{"SecretsFile": filepath.Join(dir, "missing")}, "db", "", "failed to read secrets file"},
+		{map[string]string{"Secrets": "env", "SecretsEnvPrefix": "SMUTJE_TEST_SECRET_"}, "db", "from env", ""},
+		{map[string]string{"Secrets": "env", "SecretsEnvPrefix": "SMUTJE_TEST_SECRET_"}, "unknown", "", "not found in environment"},
+		{map[string]string{"Secrets": "command", "SecretsCommand": "printf 'pwd-%s\\nmore'"}, "db", "pwd-db", ""},
+		{map[string]string{"Secrets": "command", "SecretsCommand": "false"}, "db", "", "not found using secrets command"},
+		{map[string]string{"Secrets": "encrypted", "SecretsFile": encFile, "SecretsKeyFile": keyFile}, "db", "encrypted", ""},
+		{map[string]string{"Secrets": "encrypted", "SecretsFile": encFile, "SecretsKeyFile": wrongKeyFile}, "db", "", "failed to decrypt"},
+		{map[string]string{"SecretsFile": encFile, "SecretsKeyFile": keyFile}, "db", "encrypted", ""},
+	}
+
+	for i, tti := range tt {
+		p, err := newSecretProvider(tti.cfg)
+		if err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		s, err := p.Secret(tti.name)
+		switch {
+		case tti.expErr == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.expErr != "" && err == nil:
+			t.Errorf("%d: expected an error, got none", i)
+		case tti.expErr != "" && !strings.Contains(err.Error(), tti.expErr):
+			t.Errorf("%d: expected error to contain %q, got: %s", i, tti.expErr, err)
+		case s != tti.exp:
+			t.Errorf("%d: expected secret %q, got %q", i, tti.exp, s)
+		}
+	}
+}
+
+func TestSecretProviderConfig(t *testing.T) {
+	for _, cfg := range []map[string]string{
+		{"Secrets": "unknown"},
+		{"Secrets": "command"},
+		{"Secrets": "encrypted"},
+	} {
+		if _, err := newSecretProvider(cfg); err == nil {
+			t.Errorf("expected an error for config %v, got none", cfg)
+		}
+	}
+}
+
+func TestSecretsConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ".smutje.conf")
+	if err := ioutil.WriteFile(filename, []byte("Secrets: encrypted\nSecretsFile: secrets.enc\n"), 0600); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	tt := []struct {
+		attrs    Attributes
+		filename string
+		exp      string
+	}{
+		{Attributes{}, filename, "Secrets=encrypted SecretsFile=secrets.enc"},
+		{Attributes{"SecretsKeyFile": "key"}, filename, "Secrets=encrypted SecretsFile=secrets.enc SecretsKeyFile=key"},
+		{Attributes{"SecretsFile": "other.enc"}, filename, "Secrets=encrypted SecretsFile=other.enc"},
+		{Attributes{"Secrets": "env"}, filename, "Secrets=env SecretsFile=secrets.enc"},
+		{Attributes{"SecretsFile": "pwds", "Foo": "bar"}, filename + ".missing", "SecretsFile=pwds"},
+	}
+
+	for i, tti := range tt {
+		cfg, err := secretsConfig(tti.attrs, tti.filename)
+		if err != nil {
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+			continue
+		}
+
+		got := []string{}
+		for _, key := range secretsConfigKeys {
+			if v, ok := cfg[key]; ok {
+				got = append(got, key+"="+v)
+			}
+		}
+		if strings.Join(got, " ") != tti.exp {
+			t.Errorf("%d: expected config %q, got %q", i, tti.exp, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestInjectPasswordsInvalidValue(t *testing.T) {
+	t.Setenv("SMUTJE_TEST_SECRET_tab", "a\tb")
+	t.Setenv("SMUTJE_TEST_SECRET_nl", "a\nb")
+
+	for _, name := range []string{"tab", "nl"} {
+		cmd, err := newInjectPasswordsCmd([]string{name})
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s", err)
+		}
+
+		attrs := Attributes{"Secrets": "env", "SecretsEnvPrefix": "SMUTJE_TEST_SECRET_"}
+		if _, err := cmd.Prepare(attrs, ""); err == nil {
+			t.Errorf("%s: expected an error, got none", name)
+		}
+	}
+}
+
+func TestStorePasswords(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+
+	run := func(script, stdin string) string {
+		cmd := exec.Command("bash", "-c", script)
+		cmd.Env = append(os.Environ(), "SMUTJE_RUN_DIR="+dir)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("didn't expect an error, got: %s (%s)", err, out)
+		}
+		return string(out)
+	}
+
+	run(storePasswordsCmd, "a\t1\nb\t2\n")
+	run(storePasswordsCmd, "a\tstored-secret\n")
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "passwords"))
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if exp := "a\tstored-secret\nb\t2\n"; string(content) != exp {
+		t.Errorf("expected passwords %q, got %q", exp, content)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "passwords"))
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %s", fi.Mode().Perm())
+	}
+
+	cmd, err := newInjectPasswordsCmd([]string{"a"})
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	t.Setenv("SMUTJE_TEST_SECRET_a", "stored-secret")
+	attrs := Attributes{"Secrets": "env", "SecretsEnvPrefix": "SMUTJE_TEST_SECRET_"}
+	if _, err := cmd.Prepare(attrs, ""); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if out := run("echo "+attrs["PASSWORD_a"], ""); out != "stored-secret\n" {
+		t.Errorf("expected the password to be read, got %q", out)
+	}
+}
+
+func TestSecretsFileRoundtrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "secrets.enc")
+	secrets := map[string]string{"db": "a: b", "admin": "c"}
+
+	if err := WriteSecretsFile(filename, []byte("pass"), secrets); err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+
+	got, err := ReadSecretsFile(filename, []byte("pass"))
+	if err != nil {
+		t.Fatalf("didn't expect an error, got: %s", err)
+	}
+	if len(got) != len(secrets) || got["db"] != "a: b" || got["admin"] != "c" {
+		t.Errorf("expected secrets %v, got %v", secrets, got)
+	}
+
+	if _, err := ReadSecretsFile(filename, []byte("wrong")); err == nil {
+		t.Errorf("expected an error for a wrong passphrase, got none")
+	}
+}
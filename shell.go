@@ -0,0 +1,200 @@
+package smutje
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gfrey/gconn"
+	"github.com/pkg/errors"
+)
+
+// splitArgs splits the given command line into its arguments. Arguments are
+// separated by whitespace, that can be protected by single or double quotes.
+// Inside double quotes a backslash escapes the following character. The
+// quotes themselves are removed, i.e. `cmd="curl -fs localhost"` results in
+// the single argument `cmd=curl -fs localhost`.
+func splitArgs(raw string) ([]string, error) {
+	args := []string{}
+
+	var (
+		cur     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range raw {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.Errorf("unterminated quote in %q", raw)
+	}
+
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// parseOptions extracts the options with the given keys (given in the form
+// `key=value`) from the arguments. Arguments not matching any of the keys are
+// returned in the original order.
+func parseOptions(args []string, keys ...string) (map[string]string, []string) {
+	opts := map[string]string{}
+	rest := []string{}
+	for _, arg := range args {
+		found := false
+		for _, key := range keys {
+			if strings.HasPrefix(arg, key+"=") {
+				opts[key] = arg[len(key)+1:]
+				found = true
+				break
+			}
+		}
+		if !found {
+			rest = append(rest, arg)
+		}
+	}
+	return opts, rest
+}
+
+// cutOptions removes the leading options with the given keys (given in the
+// form `key=value`, without whitespace) from the raw command line. The
+// remainder is returned unchanged, so that it can be handed to a shell.
+func cutOptions(raw string, keys ...string) (map[string]string, string) {
+	opts := map[string]string{}
+	for {
+		raw = strings.TrimSpace(raw)
+		token := raw
+		if idx := strings.IndexAny(raw, " \t"); idx != -1 {
+			token = raw[:idx]
+		}
+
+		found := false
+		for _, key := range keys {
+			if strings.HasPrefix(token, key+"=") {
+				opts[key] = token[len(key)+1:]
+				raw = raw[len(token):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return opts, raw
+		}
+	}
+}
+
+// shellQuote quotes the given value, so that it is handed to a shell as a
+// single word without any expansion applied.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// parentDirCmd returns the shell command creating the parent directory of the
+// given target, if it doesn't exist.
+func parentDirCmd(target string) string {
+	return fmt.Sprintf(`{ dir=$(dirname %s); test -d "${dir}" || mkdir -p "${dir}"; }`, shellQuote(target))
+}
+
+// execRemoteScript sends the given script to the target and runs it using
+// bash. The script is not persisted on the target.
+func execRemoteScript(l *log.Logger, client gconn.Client, script string) error {
+	sess, err := newLoggedClient(l, client).NewSession("/usr/bin/env", "bash", "-s")
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to receive stdin pipe")
+	}
+
+	if err := sess.Start(); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(stdin, script); err != nil {
+		stdin.Close()
+		return errors.Wrap(err, "failed to send script to target")
+	}
+	stdin.Close()
+
+	return sess.Wait()
+}
+
+// execRemoteScriptOutput sends the given script to the target, runs it using
+// bash and returns what was written to stdout. The script's stderr is sent to
+// the given logger.
+func execRemoteScriptOutput(l *log.Logger, client gconn.Client, script string) (string, error) {
+	sess, err := client.NewSession("/usr/bin/env", "bash", "-s")
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to receive stdin pipe")
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to receive stdout pipe")
+	}
+
+	stderr, err := sess.StderrPipe()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to receive stderr pipe")
+	}
+
+	if err := sess.Start(); err != nil {
+		return "", err
+	}
+
+	errC := make(chan error, 1)
+	go logStream(l, stderrTag, stderr, errC)
+
+	if _, err := io.WriteString(stdin, script); err != nil {
+		stdin.Close()
+		return "", errors.Wrap(err, "failed to send script to target")
+	}
+	stdin.Close()
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := io.Copy(buf, stdout); err != nil {
+		return "", errors.Wrap(err, "failed to copy output of command")
+	}
+
+	if err := <-errC; err != nil {
+		return "", err
+	}
+
+	return buf.String(), sess.Wait()
+}
@@ -0,0 +1,37 @@
+package smutje
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tt := []struct {
+		input string
+		exp   []string
+		err   string
+	}{
+		{":write_file a b", []string{":write_file", "a", "b"}, ""},
+		{"  a \t b  ", []string{"a", "b"}, ""},
+		{`a "b c" d`, []string{"a", "b c", "d"}, ""},
+		{`a 'b "c"' d`, []string{"a", `b "c"`, "d"}, ""},
+		{`cmd="curl -fs localhost"`, []string{"cmd=curl -fs localhost"}, ""},
+		{`a "b \"c\""`, []string{"a", `b "c"`}, ""},
+		{`a ""`, []string{"a", ""}, ""},
+		{`a "b`, nil, "unterminated quote"},
+	}
+
+	for i, tti := range tt {
+		got, err := splitArgs(tti.input)
+		switch {
+		case tti.err == "" && err != nil:
+			t.Errorf("%d: didn't expect an error, got: %s", i, err)
+		case tti.err != "" && err == nil:
+			t.Errorf("%d: expected error %q, got none", i, tti.err)
+		case tti.err != "" && !strings.Contains(err.Error(), tti.err):
+			t.Errorf("%d: expected error %q, got %q", i, tti.err, err)
+		case tti.err == "" && strings.Join(got, "|") != strings.Join(tti.exp, "|"):
+			t.Errorf("%d: expected %q, got %q", i, tti.exp, got)
+		}
+	}
+}
@@ -30,20 +30,20 @@ func convertToTarget(path string, astN *parser.AstNode) (*Resource, error) {
 }
 
 func Provision(res *Resource) error {
-	l := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	l := log.New(newRedactingWriter(os.Stdout), "", log.Ldate|log.Ltime)
 	return provision(l, res)
 }
 
 func provision(l *log.Logger, res *Resource) error {
 	if err := res.Prepare(l); err != nil {
-		return err
+		return redactError(err)
 	}
 
 	if err := res.Generate(l); err != nil {
-		return err
+		return redactError(err)
 	}
 
-	return res.Provision(l)
+	return redactError(res.Provision(l))
 }
 
 func ProvisionHost(l *log.Logger, host, template string) error {